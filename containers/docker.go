@@ -1,5 +1,6 @@
 package docker
 import (
+	"context"
 	"log"
 	"os/exec"
 	"fmt"
@@ -8,6 +9,11 @@ import (
 	"encoding/json"
 	"bytes"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"github.com/emc-cmd/test-framework/shared"
 )
 
 //todo: add support for volumes, ports/config. all settings must be the same to migrate
@@ -15,6 +21,52 @@ type Docker struct {
 	Name string `json:"Name"`
 	Image string `json:"Image"`
 	Command string `json:"Command"`
+	Progress ProgressFunc `json:"-"`
+	//commands and transfers run under Context, so cancelling it aborts them. nil means they can't be aborted
+	Context context.Context `json:"-"`
+}
+
+//called when an export or import enters a phase (Done is false), when it finishes it,
+//and with the times of the container's log lines around the freeze. TaskId and Time are left to the caller
+type ProgressFunc func(progress shared.Progress)
+
+//how long to wait for a restored container to log
+const firstLogTimeout = 30 * time.Second
+
+//runs f as phase, reporting to progress if it is set. f returns the bytes it handled.
+//a phase aborted by cancelling ctx is not reported as done
+func track(ctx context.Context, progress ProgressFunc, phase string, f func() int64) {
+	if progress == nil {
+		f()
+		return
+	}
+	start := time.Now()
+	progress(shared.Progress{Phase: phase})
+	bytes := f()
+	if ctx.Err() != nil {
+		return
+	}
+	progress(shared.Progress{Phase: phase, Done: true, Bytes: bytes, Duration: time.Since(start)})
+}
+
+//reports the time of a log line to progress if it is set and the line was found
+func reportLog(progress ProgressFunc, phase string, logTime time.Time) {
+	if progress == nil || logTime.IsZero() {
+		return
+	}
+	progress(shared.Progress{Phase: phase, Done: true, LogTime: logTime})
+}
+
+func (d *Docker) context() context.Context {
+	if d.Context == nil {
+		return context.Background()
+	}
+	return d.Context
+}
+
+//reports whether the container's context was cancelled, e.g. because its task was killed
+func (d *Docker) killed() bool {
+	return d.context().Err() != nil
 }
 
 type Tarball struct {
@@ -30,7 +82,7 @@ func (d *Docker) Create() string {
 		log.Fatalf("Image needs to be specified")
 	}
 	cmd := fmt.Sprintf(`create --name %s %s`, d.Name, d.Image)
-	return dockerCommand(cmd)
+	return dockerCommand(d.context(), cmd)
 }
 
 func (d *Docker) RM() string {
@@ -41,7 +93,7 @@ func (d *Docker) RM() string {
 		log.Fatalf("Image needs to be specified")
 	}
 	cmd := fmt.Sprintf(`rm %s`, d.Name)
-	return dockerCommand(cmd)
+	return dockerCommand(d.context(), cmd)
 }
 
 func (d *Docker) Start() string {
@@ -52,7 +104,7 @@ func (d *Docker) Start() string {
 		log.Fatalf("Image needs to be specified")
 	}
 	cmd := fmt.Sprintf(`start %s`, d.Name)
-	return dockerCommand(cmd)
+	return dockerCommand(d.context(), cmd)
 }
 
 func (d *Docker) Stop() string {
@@ -63,7 +115,7 @@ func (d *Docker) Stop() string {
 		log.Fatalf("Image needs to be specified")
 	}
 	cmd := fmt.Sprintf(`stop %s`, d.Name)
-	return dockerCommand(cmd)
+	return dockerCommand(d.context(), cmd)
 }
 
 func (d *Docker) Run() string {
@@ -74,45 +126,183 @@ func (d *Docker) Run() string {
 		log.Fatalf("Image needs to be specified")
 	}
 	cmd := fmt.Sprintf(`run -d --name %s %s %s`, d.Name, d.Image, d.Command)
-	return dockerCommand(cmd)
+	return dockerCommand(d.context(), cmd)
 }
 
 func (d *Docker) Logs() string {
 	if d.Name == "" {
 		log.Fatalf("Container needs to be named")
 	}
-	return dockerCommand("logs " + d.Name)
+	return dockerCommand(d.context(), "logs " + d.Name)
 }
 
-func (d *Docker) Checkpoint(imageDir string) string {
+//removes the container whether or not it is running, e.g. after its task was killed.
+//a container that doesn't exist is ignored
+func (d *Docker) Discard() {
 	if d.Name == "" {
 		log.Fatalf("Container needs to be named")
 	}
-	cmd := fmt.Sprintf(`checkpoint --image-dir=%s %s`, imageDir, d.Name)
-	out := dockerCommand(cmd)
+	cmdStr := fmt.Sprintf(`docker rm -f %s`, d.Name)
+	fmt.Printf("Running command: %s", cmdStr)
+	if out, err := shellCommand(context.Background(), cmdStr).CombinedOutput(); err != nil {
+		fmt.Printf("Failed to remove container %s: %s", d.Name, out)
+	}
+}
+
+func (d *Docker) Checkpoint(imageDir string) string {
+	out := d.freeze(imageDir)
 	out += "\n" + d.RM()
 	return out
 }
 
+//checkpoints the container, leaving it stopped but not removed. the checkpoint is not
+//aborted with the context: CRIU may have stopped the container before the command is killed
+func (d *Docker) freeze(imageDir string) string {
+	if d.Name == "" {
+		log.Fatalf("Container needs to be named")
+	}
+	cmd := fmt.Sprintf(`checkpoint --image-dir=%s %s`, imageDir, d.Name)
+	return dockerCommand(context.Background(), cmd)
+}
+
+//leaves the container running, for snapshots
+func (d *Docker) CheckpointRunning(imageDir string) string {
+	if d.Name == "" {
+		log.Fatalf("Container needs to be named")
+	}
+	cmd := fmt.Sprintf(`checkpoint --leave-running=true --image-dir=%s %s`, imageDir, d.Name)
+	return dockerCommand(d.context(), cmd)
+}
+
 func (d *Docker) Restore(imageDir string) string {
 	if d.Name == "" {
 		log.Fatalf("Container needs to be named")
 	}
 	cmd := fmt.Sprintf(`restore --force=true --image-dir=%s %s`, imageDir, d.Name)
-	out := dockerCommand(cmd)
+	out := dockerCommand(d.context(), cmd)
 	os.RemoveAll(imageDir)
 	return string(out)
 }
 
-func (d *Docker) Export(url string) string {
-	imageDir := fmt.Sprintf("/tmp/checkpoint_%s", d.Name)
-	d.Checkpoint(imageDir)
-	tarPath := fmt.Sprintf("/tmp/checkpoint_%s.tar.gz", d.Name)
-	cmdStr := fmt.Sprintf("tar czf %s %s --absolute-names", tarPath, imageDir)
-	out, err := exec.Command("/bin/sh", "-c", cmdStr).Output()
+//checkpoints the container and uploads the image. the image is staged in stagingDir.
+//the container is removed once the image is uploaded. if the export is aborted, the
+//container is restored from its image and the empty string is returned
+func (d *Docker) Export(url string, stagingDir string) string {
+	ctx := d.context()
+	imageDir := filepath.Join(stagingDir, "checkpoint_"+d.Name)
+	tarPath := filepath.Join(stagingDir, "checkpoint_"+d.Name+".tar.gz")
+	if d.killed() {
+		return d.abortExport(imageDir, tarPath, false)
+	}
+	track(ctx, d.Progress, shared.ProgressPhases.CHECKPOINTING, func() int64 {
+		d.freeze(imageDir)
+		return 0
+	})
+	if d.killed() {
+		return d.abortExport(imageDir, tarPath, true)
+	}
+	//the logs are gone once the container is removed
+	reportLog(d.Progress, shared.ProgressPhases.LAST_LOG, d.lastLogTime())
+	track(ctx, d.Progress, shared.ProgressPhases.ARCHIVING, func() int64 {
+		return archive(ctx, imageDir, tarPath)
+	})
+	if d.killed() {
+		return d.abortExport(imageDir, tarPath, true)
+	}
+	track(ctx, d.Progress, shared.ProgressPhases.UPLOADING, func() int64 {
+		return d.upload(url, tarPath)
+	})
+	if d.killed() {
+		return d.abortExport(imageDir, tarPath, true)
+	}
+	d.RM()
+	os.Remove(tarPath)
+	os.RemoveAll(imageDir)
+	return d.Name
+}
+
+//cleans up after an aborted export. a container that was already frozen is restored
+//from its image, so it keeps running where it was
+func (d *Docker) abortExport(imageDir string, tarPath string, frozen bool) string {
+	fmt.Printf("Export of %s aborted", d.Name)
+	os.Remove(tarPath)
+	if frozen {
+		container := Docker{Name: d.Name, Image: d.Image, Command: d.Command}
+		container.Restore(imageDir)
+	}
+	os.RemoveAll(imageDir)
+	return ""
+}
+
+//checkpoints without stopping the container and uploads the image like Export.
+//the last `retention` tarballs are also kept in <stagingDir>/snapshots_<name>.
+//if the snapshot is aborted, its files are removed and the empty string is returned
+func (d *Docker) Snapshot(url string, retention int, stagingDir string) string {
+	ctx := d.context()
+	imageDir := filepath.Join(stagingDir, "checkpoint_"+d.Name)
+	snapshotDir := filepath.Join(stagingDir, "snapshots_"+d.Name)
+	tarPath := fmt.Sprintf("%s/%d.tar.gz", snapshotDir, time.Now().UnixNano())
+	abort := func() string {
+		fmt.Printf("Snapshot of %s aborted", d.Name)
+		os.RemoveAll(imageDir)
+		os.Remove(tarPath)
+		return ""
+	}
+	track(ctx, d.Progress, shared.ProgressPhases.CHECKPOINTING, func() int64 {
+		d.CheckpointRunning(imageDir)
+		return 0
+	})
+	if d.killed() {
+		return abort()
+	}
+	os.MkdirAll(snapshotDir, 0755)
+	track(ctx, d.Progress, shared.ProgressPhases.ARCHIVING, func() int64 {
+		return archive(ctx, imageDir, tarPath)
+	})
+	if d.killed() {
+		return abort()
+	}
+	os.RemoveAll(imageDir)
+	track(ctx, d.Progress, shared.ProgressPhases.UPLOADING, func() int64 {
+		return d.upload(url, tarPath)
+	})
+	if d.killed() {
+		return abort()
+	}
+
+	//file names are timestamps, so they sort oldest first
+	snapshots, err := filepath.Glob(snapshotDir + "/*.tar.gz")
+	if err != nil {
+		log.Fatalf("Error listing snapshots in %s: %s", snapshotDir, err.Error())
+	}
+	sort.Strings(snapshots)
+	for len(snapshots) > retention {
+		os.Remove(snapshots[0])
+		snapshots = snapshots[1:]
+	}
+	return tarPath
+}
+
+//paths in the archive are relative to imageDir, as the image may be staged elsewhere on restore.
+//returns the size of the archive
+func archive(ctx context.Context, imageDir string, tarPath string) int64 {
+	cmdStr := fmt.Sprintf("tar czf %s -C %s .", tarPath, imageDir)
+	out, err := shellCommand(ctx, cmdStr).Output()
 	if err != nil {
+		if ctx.Err() != nil {
+			return 0
+		}
 		log.Fatalf("Error running tar command: %s, %s, %s", cmdStr, err.Error(), out)
 	}
+	info, err := os.Stat(tarPath)
+	if err != nil {
+		log.Fatalf("Error reading archive %s: %s", tarPath, err.Error())
+	}
+	return info.Size()
+}
+
+//returns the bytes uploaded
+func (d *Docker) upload(url string, tarPath string) int64 {
 	data, err := ioutil.ReadFile(tarPath)
 	if err != nil {
 		log.Fatalf("Error reading tarball during export: %s", err.Error())
@@ -129,33 +319,126 @@ func (d *Docker) Export(url string) string {
 	if err != nil {
 		log.Fatalf("Error generating request: %s", err.Error())
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := http.DefaultClient.Do(req.WithContext(d.context()))
 	if err != nil {
+		if d.killed() {
+			return 0
+		}
 		log.Fatalf("Error sending request: %s", err.Error())
 	}
+	defer resp.Body.Close()
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
+		if d.killed() {
+			return 0
+		}
 		log.Fatalf("Error reading response from upload: %s", err.Error())
 	}
 	if resp.StatusCode != 200 {
 		log.Fatalf("Upload not accepted: %s", resp.Body)
 	}
-	os.Remove(tarPath)
-	os.RemoveAll(imageDir)
-	return d.Name
+	return int64(len(data))
 }
 
-func Import(url string, containerName string) *Docker {
+//downloads a checkpoint image, stages it in stagingDir and restores the container from it.
+//progress may be nil. cancelling ctx aborts the import, which removes the staged image and
+//the half-restored container and returns nil
+func Import(ctx context.Context, url string, containerName string, stagingDir string, progress ProgressFunc) *Docker {
+	var tarball Tarball
+	track(ctx, progress, shared.ProgressPhases.DOWNLOADING, func() int64 {
+		tarball = download(ctx, url, containerName)
+		return int64(len(tarball.Data))
+	})
+	if ctx.Err() != nil {
+		fmt.Printf("Import of %s aborted", containerName)
+		return nil
+	}
+	container := tarball.Container
+	container.Context = ctx
+	restored := time.Now()
+	imageDir := ""
+	created := false
+	track(ctx, progress, shared.ProgressPhases.RESTORING, func() int64 {
+		if imageDir = unpack(ctx, tarball, stagingDir); ctx.Err() != nil {
+			return 0
+		}
+		container.Create()
+		created = ctx.Err() == nil
+		container.Restore(imageDir)
+		restored = time.Now()
+		return 0
+	})
+	if ctx.Err() != nil {
+		fmt.Printf("Import of %s aborted", containerName)
+		os.RemoveAll(imageDir)
+		if created {
+			container.Discard()
+		}
+		return nil
+	}
+	if progress != nil {
+		reportLog(progress, shared.ProgressPhases.FIRST_LOG, container.firstLogTimeAfter(restored, firstLogTimeout))
+	}
+	return &container
+}
+
+//returns the time of the container's last log line, or the zero time if there is none
+func (d *Docker) lastLogTime() time.Time {
+	return logTime(fmt.Sprintf("docker logs --timestamps --tail 1 %s", d.Name), false)
+}
+
+//waits up to timeout for the container to log after since and returns the time of
+//its first line after it, or the zero time if it stays quiet or the wait is aborted
+func (d *Docker) firstLogTimeAfter(since time.Time, timeout time.Duration) time.Time {
+	cmdStr := fmt.Sprintf("docker logs --timestamps --since %d.%09d %s", since.Unix(), since.Nanosecond(), d.Name)
+	deadline := time.Now().Add(timeout)
+	for {
+		if t := logTime(cmdStr, true); !t.IsZero() || time.Now().After(deadline) || d.killed() {
+			return t
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+//runs a `docker logs --timestamps` command and returns the time of its first or last line.
+//errors are not fatal, the logs are only used for timing
+func logTime(cmdStr string, first bool) time.Time {
+	out, err := exec.Command("/bin/sh", "-c", cmdStr).Output()
+	if err != nil {
+		fmt.Printf("Error reading logs: %s, %s", cmdStr, err.Error())
+		return time.Time{}
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	line := lines[len(lines)-1]
+	if first {
+		line = lines[0]
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.SplitN(line, " ", 2)[0])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+//returns an empty tarball if ctx is cancelled
+func download(ctx context.Context, url string, containerName string) Tarball {
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/download_container/%s", url, containerName), nil)
 	if err != nil {
 		log.Fatalf("Error generating request: %s", err.Error())
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
+		if ctx.Err() != nil {
+			return Tarball{}
+		}
 		log.Fatalf("Error sending request: %s", err.Error())
 	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
+		if ctx.Err() != nil {
+			return Tarball{}
+		}
 		log.Fatalf("Error reading response from upload: %s", err.Error())
 	}
 	var tarball Tarball
@@ -163,32 +446,50 @@ func Import(url string, containerName string) *Docker {
 	if err != nil {
 		log.Fatalf("Could not read json into tarball struct")
 	}
-	tarPath := fmt.Sprintf("/tmp/checkpoint_%s.tar.gz", containerName)
-	err = ioutil.WriteFile(tarPath, tarball.Data, 0666)
+	return tarball
+}
+
+//writes the image in tarball to stagingDir and returns the directory it is in
+func unpack(ctx context.Context, tarball Tarball, stagingDir string) string {
+	containerName := tarball.Container.Name
+	tarPath := filepath.Join(stagingDir, "checkpoint_"+containerName+".tar.gz")
+	err := ioutil.WriteFile(tarPath, tarball.Data, 0666)
 	if err != nil {
 		log.Fatalf("Could not write downloaded tarball to disk")
 	}
-	imageDir := fmt.Sprintf("/tmp/checkpoint_%s", containerName)
-	os.Mkdir(imageDir, 0666)
-	cmdStr := fmt.Sprintf("tar -xf %s -C %s  -P", tarPath, imageDir)
-	out, err := exec.Command("/bin/sh", "-c", cmdStr).Output()
+	imageDir := filepath.Join(stagingDir, "checkpoint_"+containerName)
+	os.MkdirAll(imageDir, 0755)
+	cmdStr := fmt.Sprintf("tar -xzf %s -C %s", tarPath, imageDir)
+	out, err := shellCommand(ctx, cmdStr).Output()
+	if err != nil && ctx.Err() != nil {
+		os.Remove(tarPath)
+		return imageDir
+	}
 	if err != nil {
 		log.Fatalf("Error running untar command: %s, %s, %s", cmdStr, err.Error(), out)
 	}
 	os.Remove(tarPath)
-	container := tarball.Container
-	container.Create()
-	container.Restore(imageDir)
-	return &container
+	return imageDir
 }
 
 
-func dockerCommand(command string) string {
+//runs cmdStr in a shell under ctx. the shell execs the command, so cancelling ctx kills the
+//command itself rather than just the shell
+func shellCommand(ctx context.Context, cmdStr string) *exec.Cmd {
+	return exec.CommandContext(ctx, "/bin/sh", "-c", "exec "+cmdStr)
+}
+
+//returns the empty string if ctx is cancelled
+func dockerCommand(ctx context.Context, command string) string {
 	cmdStr := fmt.Sprintf(`docker %s`, command)
 	fmt.Printf("Running command: %s", cmdStr)
-	out, err := exec.Command("/bin/sh", "-c", cmdStr).Output()
+	out, err := shellCommand(ctx, cmdStr).Output()
 	if err != nil {
-		log.Fatalf("Got error running command: ", cmdStr)
+		if ctx.Err() != nil {
+			fmt.Printf("Aborted command: %s", cmdStr)
+			return ""
+		}
+		log.Fatalf("Got error running command: %s", cmdStr)
 	}
 	fmt.Printf("Output was: %s", out)
 	return string(out)
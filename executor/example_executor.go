@@ -19,28 +19,36 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/mesos/mesos-go/executor"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	"net/http"
 	"bytes"
-	"log"
+	log "github.com/golang/glog"
 	"io/ioutil"
 	"time"
 	"math/rand"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"github.com/emc-cmd/test-framework/containers"
 	"github.com/emc-cmd/test-framework/shared"
 )
 
 type migrationExecutor struct {
+	lock          sync.Mutex
 	tasksLaunched int
+	running       map[string]context.CancelFunc //aborts the tasks being run, by ID
 }
 
 func newExampleExecutor() *migrationExecutor {
 	return &migrationExecutor{
 		tasksLaunched: 0,
+		running:       make(map[string]context.CancelFunc),
 	}
 }
 
@@ -56,15 +64,21 @@ func (mExecutor *migrationExecutor) Disconnected(executor.ExecutorDriver) {
 	fmt.Println("Executor disconnected.")
 }
 
-func (mExecutor *migrationExecutor) TestRunAndKillContainer(containerName string, url string) {
+//returns false if the test was aborted, which removes the container
+func (mExecutor *migrationExecutor) TestRunAndKillContainer(ctx context.Context, containerName string, url string) bool {
 	container := docker.Docker{
 		Name: containerName,
 		Image: "busybox:latest",
 		Command: `/bin/sh -c 'i=0; while true; do echo "%s: $i"; i=$(expr $i + 1); sleep 1; done'`,
+		Context: ctx,
 	}
 
 	//run counter in docker container
 	out := container.Run()
+	if ctx.Err() != nil {
+		container.Discard()
+		return false
+	}
 	out = out[0:len(out)-2] //for some reason necessary?
 	respBytes := writeOutputToServer("Initialized docker container: "+out, url)
 	fmt.Println("server responded with: "+ string(respBytes))
@@ -74,125 +88,236 @@ func (mExecutor *migrationExecutor) TestRunAndKillContainer(containerName string
 	r := rand.New(rand.NewSource(99))
 	seconds := r.Int() % 14 + 5
 	for i := 0; i < seconds ; i++ {
-		fmt.Printf(fmt.Sprintf("Sleeping... %v left", seconds-i-1))
-		time.Sleep(1000 * time.Millisecond)
+		fmt.Printf("Sleeping... %v left", seconds-i-1)
+		select {
+		case <-ctx.Done():
+			container.Discard()
+			return false
+		case <-time.After(1000 * time.Millisecond):
+		}
 	}
 
 	//read logs from container
 	out = container.Logs()
+	if ctx.Err() != nil {
+		container.Discard()
+		return false
+	}
 	out = out[0:len(out)-2] //for some reason necessary?
-	respBytes = writeOutputToServer(fmt.Sprintf("Slept for "+string(seconds)+"and retrieved logs: %s", out), url)
+	respBytes = writeOutputToServer(fmt.Sprintf("Slept for %d and retrieved logs: %s", seconds, out), url)
 
 	//kill & rm container
 	out = container.Stop()
+	if ctx.Err() != nil {
+		container.Discard()
+		return false
+	}
 	out = out[0:len(out)-2] //for some reason necessary?
 	respBytes = writeOutputToServer("Stopped "+containerName+": "+out, url)
 	out = container.RM()
+	if ctx.Err() != nil {
+		container.Discard()
+		return false
+	}
 	out = out[0:len(out)-2] //for some reason necessary?
 	respBytes = writeOutputToServer("Removed "+containerName+": "+out, url)
-
+	return true
 }
 
 
-func (mExecutor *migrationExecutor) StartContainer(containerName string, url string) {
+//returns false if the start was aborted, which removes the container
+func (mExecutor *migrationExecutor) StartContainer(ctx context.Context, containerName string, url string) bool {
 	container := docker.Docker{
 		Name: containerName,
 		Image: "busybox:latest",
 		Command: `/bin/sh -c 'i=0; while true; do echo "%s: $i"; i=$(expr $i + 1); sleep 1; done'`,
+		Context: ctx,
 	}
 
 	//run counter in docker container
 	out := container.Run()
+	if ctx.Err() != nil {
+		container.Discard()
+		return false
+	}
 	out = out[0:len(out)-2] //for some reason necessary?
 	respBytes := writeOutputToServer("Initialized docker container: "+out, url)
 	fmt.Println("server responded with: "+ string(respBytes))
+	return true
 }
 
-func (mExecutor *migrationExecutor) CheckpointContainer(containerName string, url string) {
+//returns false if the checkpoint was aborted
+func (mExecutor *migrationExecutor) CheckpointContainer(ctx context.Context, containerName string, url string, stagingDir string, progress docker.ProgressFunc) bool {
 	container := docker.Docker{
 		Name: containerName,
 		Image: "busybox:latest",
 		Command: `/bin/sh -c 'i=0; while true; do echo "%s: $i"; i=$(expr $i + 1); sleep 1; done'`,
+		Progress: progress,
+		Context: ctx,
 	}
 
-	out := container.Export(url)
+	out := container.Export(url, stagingDir)
+	if out == "" {
+		return false
+	}
 	out = out[0:len(out)-2] //for some reason necessary?
 	respBytes := writeOutputToServer("Checkpointed docker container: "+out, url)
 	fmt.Println("server responded with: "+ string(respBytes))
+	return true
+}
+
+//returns false if the snapshot was aborted
+func (mExecutor *migrationExecutor) SnapshotContainer(ctx context.Context, containerName string, url string, retention int, stagingDir string, progress docker.ProgressFunc) bool {
+	container := docker.Docker{
+		Name: containerName,
+		Image: "busybox:latest",
+		Command: `/bin/sh -c 'i=0; while true; do echo "%s: $i"; i=$(expr $i + 1); sleep 1; done'`,
+		Progress: progress,
+		Context: ctx,
+	}
+
+	out := container.Snapshot(url, retention, stagingDir)
+	if out == "" {
+		return false
+	}
+	respBytes := writeOutputToServer("Snapshotted docker container: "+out, url)
+	fmt.Println("server responded with: "+ string(respBytes))
+	return true
 }
 
-func (mExecutor *migrationExecutor) RestoreContainer(containerName string, url string) {
-	container := docker.Import(url, containerName)
+//returns false if the restore was aborted
+func (mExecutor *migrationExecutor) RestoreContainer(ctx context.Context, containerName string, url string, stagingDir string, progress docker.ProgressFunc) bool {
+	container := docker.Import(ctx, url, containerName, stagingDir, progress)
+	if container == nil {
+		return false
+	}
 	respBytes := writeOutputToServer(fmt.Sprintf("Restored docker container: %v", container), url)
 	fmt.Println("server responded with: "+ string(respBytes))
+	return true
 }
 
-func (mExecutor *migrationExecutor) GetLogsFromContainer(containerName string, url string) {
+//returns false if reading the logs was aborted
+func (mExecutor *migrationExecutor) GetLogsFromContainer(ctx context.Context, containerName string, url string) bool {
 	container := docker.Docker{
 		Name: containerName,
 		Image: "busybox:latest",
 		Command: `/bin/sh -c 'i=0; while true; do echo "%s: $i"; i=$(expr $i + 1); sleep 1; done'`,
+		Context: ctx,
 	}
 
 	out := container.Logs()
+	if ctx.Err() != nil {
+		return false
+	}
 	out = out[0:len(out)-2] //for some reason necessary?
 	respBytes := writeOutputToServer("Checkpointed docker container: "+out, url)
 	fmt.Println("server responded with: "+ string(respBytes))
+	return true
 }
 
 func (mExecutor *migrationExecutor) LaunchTask(driver executor.ExecutorDriver, taskInfo *mesos.TaskInfo) {
-	fmt.Printf("Launching task %v with data [%#x]\n", taskInfo.GetName(), taskInfo.Data)
+	log.Infof("Launching task %v with data [%#x]", taskInfo.GetName(), taskInfo.Data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mExecutor.lock.Lock()
+	mExecutor.running[taskInfo.GetTaskId().GetValue()] = cancel
+	mExecutor.tasksLaunched++
+	mExecutor.lock.Unlock()
+
+	//tasks run on their own goroutine, so that KillTask can abort them
+	go func() {
+		defer cancel()
+		mExecutor.runTask(ctx, driver, taskInfo)
+	}()
+}
 
+func (mExecutor *migrationExecutor) runTask(ctx context.Context, driver executor.ExecutorDriver, taskInfo *mesos.TaskInfo) {
 	runStatus := &mesos.TaskStatus{
 		TaskId: taskInfo.GetTaskId(),
 		State:  mesos.TaskState_TASK_RUNNING.Enum(),
 	}
 	_, err := driver.SendStatusUpdate(runStatus)
 	if err != nil {
-		fmt.Println("Got error", err)
+		log.Errorf("Got error: %v", err)
 	}
 
-	mExecutor.tasksLaunched++
-
 	/***
 	run task
 	 ***/
 
 	taskType, err := shared.GetValueFromLabels(taskInfo.Labels, shared.Tags.TASK_TYPE)
 	if err != nil {
-		fmt.Println("Got error", err)
+		log.Errorf("Got error: %v", err)
 	}
 	url, err := shared.GetValueFromLabels(taskInfo.Labels, shared.Tags.FILESERVER_IP)
 	if err != nil {
-		fmt.Println("Got error", err)
+		log.Errorf("Got error: %v", err)
 	}
 	containerName, err := shared.GetValueFromLabels(taskInfo.Labels, shared.Tags.CONTAINER_NAME)
 	if err != nil {
-		fmt.Println("Got error", err)
+		log.Errorf("Got error: %v", err)
 	}
+	//the staging volume is mounted relative to the sandbox, which is the working directory
+	stagingDir := "/tmp"
+	if dir, err := shared.GetValueFromLabels(taskInfo.Labels, shared.Tags.STAGING_DIR); err == nil {
+		if stagingDir, err = filepath.Abs(dir); err != nil {
+			log.Errorf("Got error: %v", err)
+			stagingDir = "/tmp"
+		}
+	}
+	progress := reportProgress(driver, taskInfo.GetTaskId().GetValue())
 
+	completed := true
 	switch taskType {
 	case shared.TaskTypes.RUN_CONTAINER:
-		mExecutor.StartContainer(containerName, url)
+		completed = mExecutor.StartContainer(ctx, containerName, url)
 		break
 	case shared.TaskTypes.CHECKPOINT_CONTAINER:
-		mExecutor.CheckpointContainer(containerName, url)
+		completed = mExecutor.CheckpointContainer(ctx, containerName, url, stagingDir, progress)
 		break
 	case shared.TaskTypes.RESTORE_CONTAINER:
-		mExecutor.RestoreContainer(containerName, url)
+		completed = mExecutor.RestoreContainer(ctx, containerName, url, stagingDir, progress)
 		break
 	case shared.TaskTypes.TEST_TASK:
-		mExecutor.TestRunAndKillContainer(containerName, url)
+		completed = mExecutor.TestRunAndKillContainer(ctx, containerName, url)
 		break
 	case shared.TaskTypes.GET_LOGS:
-		mExecutor.GetLogsFromContainer(containerName, url)
+		completed = mExecutor.GetLogsFromContainer(ctx, containerName, url)
+		break
+	case shared.TaskTypes.SNAPSHOT_CONTAINER:
+		retention := 1
+		if value, err := shared.GetValueFromLabels(taskInfo.Labels, shared.Tags.RETENTION); err == nil {
+			if retention, err = strconv.Atoi(value); err != nil {
+				log.Errorf("Got error: %v", err)
+				retention = 1
+			}
+		}
+		completed = mExecutor.SnapshotContainer(ctx, containerName, url, retention, stagingDir, progress)
 		break
 	}
 
+	mExecutor.lock.Lock()
+	delete(mExecutor.running, taskInfo.GetTaskId().GetValue())
+	mExecutor.lock.Unlock()
+
+	if !completed {
+		log.Infof("Task killed: %v", taskInfo.GetName())
+		killedStatus := &mesos.TaskStatus{
+			TaskId:  taskInfo.GetTaskId(),
+			Labels:  taskInfo.Labels,
+			State:   mesos.TaskState_TASK_KILLED.Enum(),
+			Message: proto.String("task was killed"),
+		}
+		if _, err := driver.SendStatusUpdate(killedStatus); err != nil {
+			log.Errorf("Got error: %v", err)
+		}
+		return
+	}
+
 	/***
 	 finish task
 	 ***/
-	fmt.Println("Finishing task", taskInfo.GetName())
+	log.Infof("Finishing task: %v", taskInfo.GetName())
 	finStatus := &mesos.TaskStatus{
 		TaskId: taskInfo.GetTaskId(),
 		Labels: taskInfo.Labels,
@@ -200,9 +325,25 @@ func (mExecutor *migrationExecutor) LaunchTask(driver executor.ExecutorDriver, t
 	}
 	_, err = driver.SendStatusUpdate(finStatus)
 	if err != nil {
-		fmt.Println("Got error", err)
+		log.Errorf("Got error: %v", err)
+	}
+	log.Infof("Task finished: %v", taskInfo.GetName())
+}
+
+//returns a ProgressFunc that reports the phases of a task to the scheduler
+func reportProgress(driver executor.ExecutorDriver, taskId string) docker.ProgressFunc {
+	return func(progress shared.Progress) {
+		progress.TaskId = taskId
+		progress.Time = time.Now()
+		msg, err := shared.EncodeProgress(progress)
+		if err != nil {
+			log.Errorf("Got error: %v", err)
+			return
+		}
+		if _, err := driver.SendFrameworkMessage(msg); err != nil {
+			log.Errorf("Got error: %v", err)
+		}
 	}
-	fmt.Println("Task finished", taskInfo.GetName())
 }
 
 func writeOutputToServer(output string, url string) (responseBytes []byte) {
@@ -210,18 +351,36 @@ func writeOutputToServer(output string, url string) (responseBytes []byte) {
 	req, _ := http.NewRequest("POST", url+"/in", bytes.NewReader([]byte(fmt.Sprintf(`{"in":"%s"}`, output))))
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Fatalf("Got error 2", err)
+		log.Fatalf("Got error 2: %v", err)
 	}
 	responseBytes, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("Got error 3", err)
+		log.Fatalf("Got error 3: %v", err)
 	}
 	return
 }
 
 
-func (mExecutor *migrationExecutor) KillTask(executor.ExecutorDriver, *mesos.TaskID) {
-	fmt.Println("Kill task")
+//aborts the step the task is in. the task reports TASK_KILLED once the step has cleaned up
+func (mExecutor *migrationExecutor) KillTask(driver executor.ExecutorDriver, taskId *mesos.TaskID) {
+	mExecutor.lock.Lock()
+	cancel, ok := mExecutor.running[taskId.GetValue()]
+	mExecutor.lock.Unlock()
+	if !ok {
+		//the task already finished or never ran here; mesos still expects a terminal update
+		log.Infof("Kill task: %v is not running", taskId.GetValue())
+		killedStatus := &mesos.TaskStatus{
+			TaskId:  taskId,
+			State:   mesos.TaskState_TASK_KILLED.Enum(),
+			Message: proto.String("task is not running"),
+		}
+		if _, err := driver.SendStatusUpdate(killedStatus); err != nil {
+			log.Errorf("Got error: %v", err)
+		}
+		return
+	}
+	log.Infof("Kill task: %v", taskId.GetValue())
+	cancel()
 }
 
 func (mExecutor *migrationExecutor) FrameworkMessage(driver executor.ExecutorDriver, msg string) {
@@ -229,7 +388,12 @@ func (mExecutor *migrationExecutor) FrameworkMessage(driver executor.ExecutorDri
 }
 
 func (mExecutor *migrationExecutor) Shutdown(executor.ExecutorDriver) {
-	fmt.Println("Shutting down the executor")
+	log.Infoln("Shutting down the executor")
+	mExecutor.lock.Lock()
+	defer mExecutor.lock.Unlock()
+	for _, cancel := range mExecutor.running {
+		cancel()
+	}
 }
 
 func (mExecutor *migrationExecutor) Error(driver executor.ExecutorDriver, err string) {
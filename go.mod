@@ -0,0 +1,30 @@
+module github.com/emc-cmd/test-framework
+
+go 1.21
+
+require (
+	github.com/boltdb/bolt v1.3.1
+	github.com/go-martini/martini v0.0.0-20170121215854-22fa46961aab
+	github.com/gogo/protobuf v1.3.2
+	github.com/golang/glog v1.0.0
+	github.com/mesos/mesos-go v0.0.3-0.20160213225051-45c8b08e9af6
+	github.com/prometheus/client_golang v1.11.1
+	github.com/robfig/cron v1.2.0
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/codegangsta/inject v0.0.0-20150114235600-33e0aa1cb7c0 // indirect
+	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/google/uuid v1.0.0 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/pborman/uuid v1.2.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.26.0 // indirect
+	github.com/prometheus/procfs v0.6.0 // indirect
+	github.com/samuel/go-zookeeper v0.0.0-20190923202752-2cc03de413da // indirect
+	golang.org/x/sys v0.0.0-20210603081109-ebe580a85c40 // indirect
+	google.golang.org/protobuf v1.26.0-rc.1 // indirect
+)
@@ -0,0 +1,530 @@
+// Package httpsched drives a scheduler through the Mesos v1 HTTP scheduler
+// API instead of the libprocess-based driver. The scheduler keeps a
+// subscription open to the master and sends its calls over plain HTTP
+// requests, so the master never connects back to it and the scheduler may
+// run behind NAT.
+package httpsched
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	schedproto "github.com/mesos/mesos-go/mesosproto/scheduler"
+	sched "github.com/mesos/mesos-go/scheduler"
+)
+
+const (
+	apiPath        = "/api/v1/scheduler"
+	contentType    = "application/x-protobuf"
+	streamIdHeader = "Mesos-Stream-Id"
+	maxRedirects   = 3
+
+	// The subscription is given up on once this many heartbeats are
+	// missed. Masters send one every 15 seconds unless they say otherwise.
+	defaultHeartbeat = 15 * time.Second
+	missedHeartbeats = 5
+
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+var _ sched.SchedulerDriver = &Driver{}
+
+// InverseOfferHandler is implemented by schedulers that want to hear about
+// inverse offers, which the sched.Scheduler interface has no callback for.
+type InverseOfferHandler interface {
+	InverseOffers(sched.SchedulerDriver, []*mesos.InverseOffer)
+}
+
+// Config describes the master to subscribe to and the scheduler to drive.
+type Config struct {
+	// Master is the URL of a master, or its host:port. Calls go to the
+	// leading master the subscription is redirected to.
+	Master     string
+	Scheduler  sched.Scheduler
+	Framework  *mesos.FrameworkInfo
+	Credential *mesos.Credential //sent with HTTP basic authentication, if set
+	Client     *http.Client      //http.DefaultClient's settings if nil
+}
+
+// Driver is a sched.SchedulerDriver on top of the HTTP scheduler API. Like
+// the libprocess driver, it calls the scheduler from one goroutine at a
+// time, re-subscribes when the connection to the master is lost, and tells
+// the scheduler through Disconnected and Reregistered.
+type Driver struct {
+	lock       sync.Mutex
+	config     Config
+	client     *http.Client
+	endpoint   *url.URL //of the master the subscription is open to
+	status     mesos.Status
+	streamId   string //empty while not subscribed
+	stream     io.Closer
+	registered bool //whether the driver ever subscribed
+	done       chan struct{}
+}
+
+// NewDriver returns a driver of config.Scheduler. It doesn't contact the
+// master until it is started.
+func NewDriver(config Config) (*Driver, error) {
+	if config.Scheduler == nil || config.Framework == nil {
+		return nil, fmt.Errorf("a driver needs a scheduler and framework info")
+	}
+	master := config.Master
+	if !strings.Contains(master, "://") {
+		master = "http://" + master
+	}
+	endpoint, err := url.Parse(master)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master %q: %v", config.Master, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("the HTTP scheduler API needs the URL of a master, not %q", config.Master)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + apiPath
+	// Like the libprocess driver, subscribe as the current user unless the
+	// framework names one.
+	if config.Framework.GetUser() == "" {
+		framework := *config.Framework
+		framework.User = proto.String("")
+		if current, err := user.Current(); err != nil {
+			log.Warningf("Failed to obtain username: %v", err)
+		} else {
+			framework.User = proto.String(current.Username)
+		}
+		config.Framework = &framework
+	}
+	client := &http.Client{}
+	if config.Client != nil {
+		*client = *config.Client
+	}
+	// Redirects to the leading master are followed by hand, so that the
+	// credentials go along and later calls go to the leader as well.
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &Driver{
+		config:   config,
+		client:   client,
+		endpoint: endpoint,
+		status:   mesos.Status_DRIVER_NOT_STARTED,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (d *Driver) Start() (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.status != mesos.Status_DRIVER_NOT_STARTED {
+		return d.status, fmt.Errorf("driver is already %s", d.status)
+	}
+	d.status = mesos.Status_DRIVER_RUNNING
+	go d.run()
+	return d.status, nil
+}
+
+// Stop closes the subscription. Unless failover is set, it tears the
+// framework down first, which kills its tasks.
+func (d *Driver) Stop(failover bool) (mesos.Status, error) {
+	if !failover {
+		if _, err := d.send(&schedproto.Call{Type: schedproto.Call_TEARDOWN.Enum()}); err != nil {
+			log.Errorf("Failed to tear down the framework: %v", err)
+		}
+	}
+	return d.stop(mesos.Status_DRIVER_STOPPED)
+}
+
+func (d *Driver) Abort() (mesos.Status, error) {
+	return d.stop(mesos.Status_DRIVER_ABORTED)
+}
+
+func (d *Driver) stop(status mesos.Status) (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.status != mesos.Status_DRIVER_RUNNING {
+		return d.status, fmt.Errorf("driver is not running")
+	}
+	d.status = status
+	d.streamId = ""
+	if d.stream != nil {
+		d.stream.Close()
+	}
+	close(d.done)
+	return status, nil
+}
+
+func (d *Driver) Join() (mesos.Status, error) {
+	d.lock.Lock()
+	if d.status == mesos.Status_DRIVER_NOT_STARTED {
+		defer d.lock.Unlock()
+		return d.status, fmt.Errorf("driver is not started")
+	}
+	d.lock.Unlock()
+	<-d.done
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.status, nil
+}
+
+func (d *Driver) Run() (mesos.Status, error) {
+	if status, err := d.Start(); err != nil {
+		return status, err
+	}
+	return d.Join()
+}
+
+func (d *Driver) running() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.status == mesos.Status_DRIVER_RUNNING
+}
+
+// run keeps a subscription open until the driver stops, backing off
+// between attempts to subscribe.
+func (d *Driver) run() {
+	backoff := minBackoff
+	for {
+		subscribed, err := d.subscribe()
+		if !d.running() {
+			return
+		}
+		log.Errorf("Subscription to %s ended: %v", d.master(), err)
+		if subscribed {
+			backoff = minBackoff
+			d.config.Scheduler.Disconnected(d)
+		}
+		select {
+		case <-d.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (d *Driver) master() string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.endpoint.Host
+}
+
+// subscribe subscribes to the master and handles its events until the
+// subscription ends. It reports whether the master accepted the
+// subscription.
+func (d *Driver) subscribe() (bool, error) {
+	d.lock.Lock()
+	call := &schedproto.Call{
+		Type:        schedproto.Call_SUBSCRIBE.Enum(),
+		FrameworkId: d.config.Framework.GetId(),
+		Subscribe:   &schedproto.Call_Subscribe{FrameworkInfo: d.config.Framework},
+	}
+	endpoint := d.endpoint
+	d.lock.Unlock()
+
+	var resp *http.Response
+	for redirects := 0; ; redirects++ {
+		var err error
+		if resp, err = d.post(endpoint, "", call); err != nil {
+			return false, err
+		}
+		if resp.StatusCode != http.StatusTemporaryRedirect || redirects == maxRedirects {
+			break
+		}
+		location, err := endpoint.Parse(resp.Header.Get("Location"))
+		resp.Body.Close()
+		if err != nil {
+			return false, fmt.Errorf("invalid redirect to the leading master: %v", err)
+		}
+		log.Infof("Redirected to the leading master at %s", location.Host)
+		endpoint = location
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, responseError(resp)
+	}
+
+	d.lock.Lock()
+	if d.status != mesos.Status_DRIVER_RUNNING {
+		d.lock.Unlock()
+		return false, fmt.Errorf("driver is not running")
+	}
+	d.endpoint = endpoint
+	d.stream = resp.Body
+	d.lock.Unlock()
+	defer func() {
+		d.lock.Lock()
+		d.streamId = ""
+		d.stream = nil
+		d.lock.Unlock()
+	}()
+
+	timeout := missedHeartbeats * defaultHeartbeat
+	heartbeat := time.AfterFunc(timeout, func() {
+		log.Errorf("Missed %d heartbeats of %s", missedHeartbeats, endpoint.Host)
+		resp.Body.Close()
+	})
+	defer heartbeat.Stop()
+
+	records := newRecordReader(resp.Body)
+	subscribed := false
+	for {
+		record, err := records.next()
+		if err != nil {
+			return subscribed, err
+		}
+		heartbeat.Reset(timeout)
+		event := &schedproto.Event{}
+		if err := proto.Unmarshal(record, event); err != nil {
+			return subscribed, fmt.Errorf("invalid event: %v", err)
+		}
+		if event.GetType() == schedproto.Event_SUBSCRIBED {
+			if interval := event.GetSubscribed().GetHeartbeatIntervalSeconds(); interval > 0 {
+				timeout = missedHeartbeats * time.Duration(interval*float64(time.Second))
+				heartbeat.Reset(timeout)
+			}
+			subscribed = true
+			d.subscribed(resp.Header.Get(streamIdHeader), event.GetSubscribed())
+			continue
+		}
+		if !subscribed {
+			return false, fmt.Errorf("expected a SUBSCRIBED event, got %s", event.GetType())
+		}
+		d.handle(event)
+	}
+}
+
+// subscribed records a subscription and tells the scheduler.
+func (d *Driver) subscribed(streamId string, event *schedproto.Event_Subscribed) {
+	d.lock.Lock()
+	d.streamId = streamId
+	d.config.Framework.Id = event.GetFrameworkId()
+	resubscribed := d.registered
+	d.registered = true
+	info := masterInfo(d.endpoint)
+	d.lock.Unlock()
+
+	log.Infof("Subscribed to %s as framework %s", info.GetHostname(), event.GetFrameworkId().GetValue())
+	if resubscribed {
+		d.config.Scheduler.Reregistered(d, info)
+	} else {
+		d.config.Scheduler.Registered(d, event.GetFrameworkId(), info)
+	}
+}
+
+// handle passes an event on to the scheduler.
+func (d *Driver) handle(event *schedproto.Event) {
+	s := d.config.Scheduler
+	switch event.GetType() {
+	case schedproto.Event_OFFERS:
+		if offers := event.GetOffers().GetOffers(); len(offers) > 0 {
+			s.ResourceOffers(d, offers)
+		}
+		if inverseOffers := event.GetOffers().GetInverseOffers(); len(inverseOffers) > 0 {
+			if handler, ok := s.(InverseOfferHandler); ok {
+				handler.InverseOffers(d, inverseOffers)
+			} else {
+				log.Infof("Ignoring %d inverse offers", len(inverseOffers))
+			}
+		}
+	case schedproto.Event_RESCIND:
+		s.OfferRescinded(d, event.GetRescind().GetOfferId())
+	case schedproto.Event_UPDATE:
+		status := event.GetUpdate().GetStatus()
+		s.StatusUpdate(d, status)
+		d.acknowledge(status)
+	case schedproto.Event_MESSAGE:
+		message := event.GetMessage()
+		s.FrameworkMessage(d, message.GetExecutorId(), message.GetSlaveId(), string(message.GetData()))
+	case schedproto.Event_FAILURE:
+		failure := event.GetFailure()
+		if failure.GetExecutorId() != nil {
+			s.ExecutorLost(d, failure.GetExecutorId(), failure.GetSlaveId(), int(failure.GetStatus()))
+		} else {
+			s.SlaveLost(d, failure.GetSlaveId())
+		}
+	case schedproto.Event_ERROR:
+		// The master has removed the framework; like the libprocess
+		// driver, give up.
+		s.Error(d, event.GetError().GetMessage())
+		d.Abort()
+	case schedproto.Event_HEARTBEAT:
+	default:
+		log.Infof("Ignoring %s event", event.GetType())
+	}
+}
+
+// acknowledge acknowledges a status update, which the HTTP API leaves to
+// the scheduler. Updates without a UUID don't need acknowledging.
+func (d *Driver) acknowledge(status *mesos.TaskStatus) {
+	if len(status.GetUuid()) == 0 {
+		return
+	}
+	_, err := d.send(&schedproto.Call{
+		Type: schedproto.Call_ACKNOWLEDGE.Enum(),
+		Acknowledge: &schedproto.Call_Acknowledge{
+			SlaveId: status.GetSlaveId(),
+			TaskId:  status.GetTaskId(),
+			Uuid:    status.GetUuid(),
+		},
+	})
+	if err != nil {
+		log.Errorf("Failed to acknowledge the status update of task %s: %v", status.GetTaskId().GetValue(), err)
+	}
+}
+
+// send makes a call to the master the driver is subscribed to.
+func (d *Driver) send(call *schedproto.Call) (mesos.Status, error) {
+	d.lock.Lock()
+	status, streamId, endpoint := d.status, d.streamId, d.endpoint
+	call.FrameworkId = d.config.Framework.GetId()
+	d.lock.Unlock()
+	if status != mesos.Status_DRIVER_RUNNING {
+		return status, fmt.Errorf("driver is not running")
+	}
+	if streamId == "" {
+		return status, fmt.Errorf("not subscribed to a master")
+	}
+	resp, err := d.post(endpoint, streamId, call)
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return status, responseError(resp)
+	}
+	return status, nil
+}
+
+func (d *Driver) post(endpoint *url.URL, streamId string, call *schedproto.Call) (*http.Response, error) {
+	body, err := proto.Marshal(call)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	if streamId != "" {
+		req.Header.Set(streamIdHeader, streamId)
+	}
+	if credential := d.config.Credential; credential != nil {
+		req.SetBasicAuth(credential.GetPrincipal(), credential.GetSecret())
+	}
+	return d.client.Do(req)
+}
+
+// responseError turns a response the master rejected a call with into an
+// error, keeping the start of the reason the master gives.
+func responseError(resp *http.Response) error {
+	reason, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if len(bytes.TrimSpace(reason)) == 0 {
+		return fmt.Errorf("master replied %s", resp.Status)
+	}
+	return fmt.Errorf("master replied %s: %s", resp.Status, bytes.TrimSpace(reason))
+}
+
+// masterInfo describes the master at endpoint. SUBSCRIBED events don't carry
+// the master's info, and the scheduler only logs it.
+func masterInfo(endpoint *url.URL) *mesos.MasterInfo {
+	host := endpoint.Host
+	port := 0
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		port, _ = strconv.Atoi(host[i+1:])
+		host = host[:i]
+	}
+	return &mesos.MasterInfo{
+		Id:       proto.String(endpoint.Host),
+		Ip:       proto.Uint32(0),
+		Port:     proto.Uint32(uint32(port)),
+		Hostname: proto.String(host),
+	}
+}
+
+func (d *Driver) RequestResources(requests []*mesos.Request) (mesos.Status, error) {
+	return d.send(&schedproto.Call{
+		Type:    schedproto.Call_REQUEST.Enum(),
+		Request: &schedproto.Call_Request{Requests: requests},
+	})
+}
+
+func (d *Driver) AcceptOffers(offerIds []*mesos.OfferID, operations []*mesos.Offer_Operation, filters *mesos.Filters) (mesos.Status, error) {
+	return d.send(&schedproto.Call{
+		Type: schedproto.Call_ACCEPT.Enum(),
+		Accept: &schedproto.Call_Accept{
+			OfferIds:   offerIds,
+			Operations: operations,
+			Filters:    filters,
+		},
+	})
+}
+
+func (d *Driver) LaunchTasks(offerIds []*mesos.OfferID, tasks []*mesos.TaskInfo, filters *mesos.Filters) (mesos.Status, error) {
+	launch := &mesos.Offer_Operation{
+		Type:   mesos.Offer_Operation_LAUNCH.Enum(),
+		Launch: &mesos.Offer_Operation_Launch{TaskInfos: tasks},
+	}
+	return d.AcceptOffers(offerIds, []*mesos.Offer_Operation{launch}, filters)
+}
+
+func (d *Driver) KillTask(taskId *mesos.TaskID) (mesos.Status, error) {
+	return d.send(&schedproto.Call{
+		Type: schedproto.Call_KILL.Enum(),
+		Kill: &schedproto.Call_Kill{TaskId: taskId},
+	})
+}
+
+func (d *Driver) DeclineOffer(offerId *mesos.OfferID, filters *mesos.Filters) (mesos.Status, error) {
+	return d.send(&schedproto.Call{
+		Type: schedproto.Call_DECLINE.Enum(),
+		Decline: &schedproto.Call_Decline{
+			OfferIds: []*mesos.OfferID{offerId},
+			Filters:  filters,
+		},
+	})
+}
+
+func (d *Driver) ReviveOffers() (mesos.Status, error) {
+	return d.send(&schedproto.Call{Type: schedproto.Call_REVIVE.Enum()})
+}
+
+func (d *Driver) SendFrameworkMessage(executorId *mesos.ExecutorID, slaveId *mesos.SlaveID, data string) (mesos.Status, error) {
+	return d.send(&schedproto.Call{
+		Type: schedproto.Call_MESSAGE.Enum(),
+		Message: &schedproto.Call_Message{
+			SlaveId:    slaveId,
+			ExecutorId: executorId,
+			Data:       []byte(data),
+		},
+	})
+}
+
+// ReconcileTasks asks the master for the state of the given tasks, or of
+// every task the framework has if statuses is empty.
+func (d *Driver) ReconcileTasks(statuses []*mesos.TaskStatus) (mesos.Status, error) {
+	tasks := []*schedproto.Call_Reconcile_Task{}
+	for _, status := range statuses {
+		tasks = append(tasks, &schedproto.Call_Reconcile_Task{
+			TaskId:  status.GetTaskId(),
+			SlaveId: status.GetSlaveId(),
+		})
+	}
+	return d.send(&schedproto.Call{
+		Type:      schedproto.Call_RECONCILE.Enum(),
+		Reconcile: &schedproto.Call_Reconcile{Tasks: tasks},
+	})
+}
@@ -0,0 +1,293 @@
+package httpsched
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	schedproto "github.com/mesos/mesos-go/mesosproto/scheduler"
+	util "github.com/mesos/mesos-go/mesosutil"
+	sched "github.com/mesos/mesos-go/scheduler"
+	"github.com/emc-cmd/test-framework/schedtest"
+	"github.com/emc-cmd/test-framework/scheduler"
+)
+
+const (
+	testStreamId    = "stream-1"
+	testFrameworkId = "framework-1"
+	testTimeout     = 5 * time.Second
+)
+
+// fakeMaster is a stand-in for the scheduler API of a master. It accepts
+// subscriptions, streams the events a test sends, and passes the calls it
+// gets on to the test.
+type fakeMaster struct {
+	server     *httptest.Server
+	subscribes chan *schedproto.Call
+	calls      chan *schedproto.Call
+	events     chan *schedproto.Event
+	hangup     chan struct{}
+}
+
+func newFakeMaster() *fakeMaster {
+	m := &fakeMaster{
+		subscribes: make(chan *schedproto.Call, 10),
+		calls:      make(chan *schedproto.Call, 100),
+		events:     make(chan *schedproto.Event),
+		hangup:     make(chan struct{}),
+	}
+	m.server = httptest.NewServer(m)
+	return m
+}
+
+func (m *fakeMaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	call := &schedproto.Call{}
+	if err := proto.Unmarshal(body, call); err != nil || r.URL.Path != apiPath {
+		http.Error(w, "invalid call", http.StatusBadRequest)
+		return
+	}
+	if call.GetType() != schedproto.Call_SUBSCRIBE {
+		if r.Header.Get(streamIdHeader) != testStreamId {
+			http.Error(w, "unknown stream", http.StatusBadRequest)
+			return
+		}
+		m.calls <- call
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	m.subscribes <- call
+	w.Header().Set(streamIdHeader, testStreamId)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	m.write(w, &schedproto.Event{
+		Type: schedproto.Event_SUBSCRIBED.Enum(),
+		Subscribed: &schedproto.Event_Subscribed{
+			FrameworkId:              util.NewFrameworkID(testFrameworkId),
+			HeartbeatIntervalSeconds: proto.Float64(1),
+		},
+	})
+	for {
+		select {
+		case event := <-m.events:
+			m.write(w, event)
+		case <-m.hangup:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (m *fakeMaster) write(w http.ResponseWriter, event *schedproto.Event) {
+	record, _ := proto.Marshal(event)
+	fmt.Fprintf(w, "%d\n%s", len(record), record)
+	w.(http.Flusher).Flush()
+}
+
+func (m *fakeMaster) send(t *testing.T, event *schedproto.Event) {
+	if _, err := proto.Marshal(event); err != nil {
+		t.Fatalf("invalid %s event: %v", event.GetType(), err)
+	}
+	select {
+	case m.events <- event:
+	case <-time.After(testTimeout):
+		t.Fatalf("nobody is subscribed to get the %s event", event.GetType())
+	}
+}
+
+// expect returns the next call of type callType, skipping other calls.
+func (m *fakeMaster) expect(t *testing.T, callType schedproto.Call_Type) *schedproto.Call {
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case call := <-m.calls:
+			if call.GetType() == callType {
+				return call
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for a %s call", callType)
+		}
+	}
+}
+
+func (m *fakeMaster) subscription(t *testing.T) *schedproto.Call {
+	select {
+	case call := <-m.subscribes:
+		return call
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a subscription")
+		return nil
+	}
+}
+
+func TestDriverDrivesScheduler(t *testing.T) {
+	master := newFakeMaster()
+	defer master.server.Close()
+	exec := util.NewExecutorInfo(util.NewExecutorID("default"), util.NewCommandInfo("./executor"))
+	s := scheduler.NewExampleScheduler(exec, 0, 1, 128, "http://fileserver")
+	driver, err := NewDriver(Config{
+		Master:    master.server.URL,
+		Scheduler: s,
+		Framework: &mesos.FrameworkInfo{Name: proto.String("test")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RunContainerTask("web")
+	if _, err := driver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Stop(true)
+
+	subscribe := master.subscription(t)
+	if name := subscribe.GetSubscribe().GetFrameworkInfo().GetName(); name != "test" {
+		t.Errorf("subscribed as %q, want test", name)
+	}
+
+	master.send(t, &schedproto.Event{
+		Type:   schedproto.Event_OFFERS.Enum(),
+		Offers: &schedproto.Event_Offers{Offers: []*mesos.Offer{schedtest.NewOffer("offer-1", "host-1").Cpus(4).Mem(4096).Build()}},
+	})
+	accept := master.expect(t, schedproto.Call_ACCEPT).GetAccept()
+	if len(accept.GetOfferIds()) != 1 || accept.GetOfferIds()[0].GetValue() != "offer-1" {
+		t.Fatalf("accepted offers %v, want offer-1", accept.GetOfferIds())
+	}
+	operations := accept.GetOperations()
+	if len(operations) != 1 || len(operations[0].GetLaunch().GetTaskInfos()) != 1 {
+		t.Fatalf("accepted with operations %v, want one launch of one task", operations)
+	}
+	task := operations[0].GetLaunch().GetTaskInfos()[0]
+
+	status := schedtest.StatusFor(task, mesos.TaskState_TASK_FINISHED).Build()
+	status.Uuid = []byte("uuid-1")
+	master.send(t, &schedproto.Event{
+		Type:   schedproto.Event_UPDATE.Enum(),
+		Update: &schedproto.Event_Update{Status: status},
+	})
+	ack := master.expect(t, schedproto.Call_ACKNOWLEDGE).GetAcknowledge()
+	if ack.GetTaskId().GetValue() != task.GetTaskId().GetValue() || string(ack.GetUuid()) != "uuid-1" {
+		t.Errorf("acknowledged task %s with uuid %q, want %s with uuid-1", ack.GetTaskId().GetValue(), ack.GetUuid(), task.GetTaskId().GetValue())
+	}
+	if host, _ := s.GetContainerHost("web"); host != "host-1" {
+		t.Errorf("container is on %q, want host-1", host)
+	}
+
+	start := time.Now().Add(time.Hour)
+	master.send(t, &schedproto.Event{
+		Type: schedproto.Event_OFFERS.Enum(),
+		Offers: &schedproto.Event_Offers{InverseOffers: []*mesos.InverseOffer{{
+			Id:             util.NewOfferID("inverse-1"),
+			FrameworkId:    util.NewFrameworkID(testFrameworkId),
+			SlaveId:        util.NewSlaveID("slave-host-1"),
+			Unavailability: &mesos.Unavailability{Start: &mesos.TimeInfo{Nanoseconds: proto.Int64(start.UnixNano())}},
+		}}},
+	})
+	for deadline := time.Now().Add(testTimeout); len(s.MaintenanceWindows()) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the inverse offer to announce maintenance")
+		}
+	}
+	if windows := s.MaintenanceWindows(); windows[0].Host != "host-1" || !windows[0].Start.Equal(start) {
+		t.Errorf("maintenance windows are %+v, want host-1 from %v", windows, start)
+	}
+}
+
+// recorder is a scheduler that records which callbacks the driver makes.
+type recorder struct {
+	callbacks chan string
+}
+
+func (r *recorder) Registered(sched.SchedulerDriver, *mesos.FrameworkID, *mesos.MasterInfo) {
+	r.callbacks <- "Registered"
+}
+
+func (r *recorder) Reregistered(sched.SchedulerDriver, *mesos.MasterInfo) {
+	r.callbacks <- "Reregistered"
+}
+
+func (r *recorder) Disconnected(sched.SchedulerDriver) {
+	r.callbacks <- "Disconnected"
+}
+
+func (r *recorder) ResourceOffers(sched.SchedulerDriver, []*mesos.Offer) {
+	r.callbacks <- "ResourceOffers"
+}
+
+func (r *recorder) OfferRescinded(sched.SchedulerDriver, *mesos.OfferID) {
+	r.callbacks <- "OfferRescinded"
+}
+
+func (r *recorder) StatusUpdate(sched.SchedulerDriver, *mesos.TaskStatus) {
+	r.callbacks <- "StatusUpdate"
+}
+
+func (r *recorder) FrameworkMessage(sched.SchedulerDriver, *mesos.ExecutorID, *mesos.SlaveID, string) {
+	r.callbacks <- "FrameworkMessage"
+}
+
+func (r *recorder) SlaveLost(sched.SchedulerDriver, *mesos.SlaveID) {
+	r.callbacks <- "SlaveLost"
+}
+
+func (r *recorder) ExecutorLost(sched.SchedulerDriver, *mesos.ExecutorID, *mesos.SlaveID, int) {
+	r.callbacks <- "ExecutorLost"
+}
+
+func (r *recorder) Error(sched.SchedulerDriver, string) {
+	r.callbacks <- "Error"
+}
+
+func (r *recorder) expect(t *testing.T, callback string) {
+	select {
+	case got := <-r.callbacks:
+		if got != callback {
+			t.Fatalf("scheduler got %s, want %s", got, callback)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out waiting for %s", callback)
+	}
+}
+
+func TestDriverResubscribes(t *testing.T) {
+	master := newFakeMaster()
+	defer master.server.Close()
+	// The driver is pointed at a master that redirects to the leader.
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, master.server.URL+apiPath, http.StatusTemporaryRedirect)
+	}))
+	defer follower.Close()
+	s := &recorder{callbacks: make(chan string, 10)}
+	driver, err := NewDriver(Config{Master: follower.URL, Scheduler: s, Framework: &mesos.FrameworkInfo{Name: proto.String("test")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := driver.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := master.subscription(t).GetFrameworkId(); id != nil {
+		t.Errorf("first subscription has framework ID %s, want none", id.GetValue())
+	}
+	s.expect(t, "Registered")
+	master.hangup <- struct{}{}
+	s.expect(t, "Disconnected")
+	if id := master.subscription(t).GetFrameworkId().GetValue(); id != testFrameworkId {
+		t.Errorf("resubscribed with framework ID %q, want %s", id, testFrameworkId)
+	}
+	s.expect(t, "Reregistered")
+
+	master.send(t, &schedproto.Event{
+		Type:  schedproto.Event_ERROR.Enum(),
+		Error: &schedproto.Event_Error{Message: proto.String("framework removed")},
+	})
+	s.expect(t, "Error")
+	if status, _ := driver.Join(); status != mesos.Status_DRIVER_ABORTED {
+		t.Errorf("driver is %s after an error, want %s", status, mesos.Status_DRIVER_ABORTED)
+	}
+}
@@ -0,0 +1,42 @@
+package httpsched
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxRecordSize bounds the records read from the event stream, so that a
+// corrupt length doesn't make the driver allocate gigabytes.
+const maxRecordSize = 64 << 20
+
+// recordReader reads the RecordIO framing of the event stream: every record
+// is its length in bytes, in decimal, followed by a newline and the record.
+type recordReader struct {
+	r *bufio.Reader
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{r: bufio.NewReader(r)}
+}
+
+func (r *recordReader) next() ([]byte, error) {
+	header, err := r.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseUint(strings.TrimSpace(header), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record length %q", header)
+	}
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("record of %d bytes is too large", size)
+	}
+	record := make([]byte, size)
+	if _, err := io.ReadFull(r.r, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -19,19 +19,30 @@
 package main
 
 import (
+	"bytes"
 	"flag"
+	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
+	"golang.org/x/net/context"
 
 	log "github.com/golang/glog"
+	"github.com/mesos/mesos-go/auth"
+	"github.com/mesos/mesos-go/auth/sasl"
+	_ "github.com/mesos/mesos-go/auth/sasl/mech/crammd5"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	util "github.com/mesos/mesos-go/mesosutil"
 	sched "github.com/mesos/mesos-go/scheduler"
+	"github.com/emc-cmd/test-framework/httpsched"
 	. "github.com/emc-cmd/test-framework/scheduler"
 	. "github.com/emc-cmd/test-framework/server"
+	"github.com/emc-cmd/test-framework/simulator"
+	"github.com/emc-cmd/test-framework/store"
 	"github.com/emc-cmd/test-framework/trigger"
 )
 
@@ -46,9 +57,45 @@ var (
 	address      = flag.String("address", "127.0.0.1", "Binding address for artifact server")
 	artifactPort = flag.Int("artifactPort", defaultArtifactPort, "Binding port for artifact server")
 	master       = flag.String("master", "127.0.0.1:5050", "Master address <ip:port>")
+	transport    = flag.String("transport", "libprocess", "How to talk to the master: libprocess, or http for the v1 HTTP scheduler API, which doesn't need the master to reach --address. With http, --master is the URL or <ip:port> of a master.")
 	executorPath = flag.String("executor", "./example_executor", "Path to test executor")
 	taskCount    = flag.String("task-count", "5", "Total task count to run.")
 	externalServer    = flag.String("externalServer", "http://192.168.0.15:3000", "IP Address of the external server for hosting container files.")
+	cpusPerTask  = flag.Float64("cpus-per-task", CPUS_PER_TASK, "CPUs for containers that don't ask for their own.")
+	memPerTask   = flag.Float64("mem-per-task", MEM_PER_TASK, "Memory (MB) for containers that don't ask for their own.")
+	refuseSeconds = flag.Float64("refuse-seconds", 5, "Seconds to refuse offers that don't fit any queued task.")
+	suppressSeconds = flag.Float64("suppress-seconds", 300, "Seconds to refuse offers while no tasks are queued. Queuing a task revives offers.")
+	stateFile    = flag.String("state-file", "test-framework.db", "Path to the local database holding scheduler state. Empty disables persistence.")
+	recoveryPolicy = flag.String("recovery-policy", RecoveryPolicies.NONE, "Default recovery of containers on a lost agent: none, last-checkpoint or restart-fresh.")
+	drainConcurrency = flag.Int("drain-concurrency", 1, "Containers a host drain migrates at a time, unless the drain asks for another limit.")
+	maintenanceLead = flag.Duration("maintenance-lead", time.Hour, "How long before an agent's maintenance window its containers are evacuated.")
+	placementStrategy = flag.String("placement-strategy", PlacementStrategyNames.FIRST_FIT, "Placement of containers that don't ask for their own strategy: first-fit, bin-pack, spread or random.")
+	rebalanceInterval = flag.Duration("rebalance-interval", 0, "How often to move containers from busy to idle agents. 0 disables rebalancing.")
+	rebalanceThreshold = flag.Int("rebalance-threshold", 1, "Rebalance only agents whose container counts differ by more than this.")
+	rebalanceBudget = flag.Int("rebalance-budget", 1, "Migrations the rebalancer may start per interval.")
+	rebalanceCooldown = flag.Duration("rebalance-cooldown", 30*time.Minute, "How long after a move the rebalancer leaves a container alone.")
+	rebalanceDryRun = flag.Bool("rebalance-dry-run", false, "Only log the moves the rebalancer would make.")
+	failoverTimeout = flag.Float64("failover-timeout", 3600, "Seconds the master waits for the scheduler to fail over before killing its tasks.")
+	principal    = flag.String("principal", "", "Principal the framework registers and authenticates as.")
+	secretFile   = flag.String("secret-file", "", "File holding the secret of --principal. Setting it turns on authentication.")
+	authProvider = flag.String("auth-provider", sasl.ProviderName, "Authentication provider to use with --secret-file.")
+	role         = flag.String("role", "*", "Role the framework receives offers for.")
+	hostname     = flag.String("hostname", "", "Hostname the framework advertises to the master. Defaults to the local hostname.")
+	webuiUrl     = flag.String("webui-url", "", "URL the Mesos web UI links to for this framework.")
+	checkpoint   = flag.Bool("checkpoint", true, "Have agents checkpoint the framework's tasks, so they survive agent restarts.")
+	stagingDisk  = flag.Float64("staging-disk", 0, "Disk (MB) to reserve on every agent for a persistent volume to stage checkpoints in. Needs --role and --principal. 0 stages in /tmp.")
+	registrationTimeout = flag.Duration("registration-timeout", time.Minute, "Give up if the framework hasn't registered with a master within this time. 0 waits forever.")
+	simulate     = flag.Int("simulate", 0, "Run against this many simulated hosts instead of a Mesos master. 0 uses --master.")
+	simCpus      = flag.Float64("sim-cpus", 4, "CPUs of every simulated host.")
+	simMem       = flag.Float64("sim-mem", 8192, "Memory (MB) of every simulated host.")
+	simDisk      = flag.Float64("sim-disk", 65536, "Disk (MB) of every simulated host.")
+	simAttributes = flag.String("sim-attributes", "", "Attributes of the simulated hosts, name=value|value,..., e.g. rack=r1|r2. The values are handed out to the hosts in turn.")
+	simOfferInterval = flag.Duration("sim-offer-interval", time.Second, "How often the simulated master offers free resources.")
+	simLatency   = flag.Duration("sim-latency", 2*time.Second, "How long a simulated task runs.")
+	simLatencies = flag.String("sim-latencies", "", "How long simulated tasks of a type run, TASK_TYPE=duration,..., e.g. CHECKPOINT_CONTAINER=10s.")
+	simJitter    = flag.Float64("sim-jitter", 0.2, "How much simulated task latencies vary, as a fraction of the latency.")
+	simFailureRate = flag.Float64("sim-failure-rate", 0, "Chance of a simulated task failing, from 0 to 1.")
+	simSeed      = flag.Int64("sim-seed", 1, "Seed of the simulation's random choices.")
 )
 
 func init() {
@@ -70,12 +117,77 @@ func main() {
 		os.Exit(-1)
 	}
 
-	scheduler := NewExampleScheduler(exec, numTasks, CPUS_PER_TASK, MEM_PER_TASK, *externalServer)
+	scheduler := NewExampleScheduler(exec, numTasks, *cpusPerTask, *memPerTask, *externalServer)
 	if err != nil {
 		log.Fatalf("Failed to create scheduler with error: %v\n", err)
 		os.Exit(-2)
 	}
 
+	scheduler.SetOfferFilters(*refuseSeconds, *suppressSeconds)
+	if *drainConcurrency < 1 {
+		log.Fatalf("Invalid --drain-concurrency: must be at least 1\n")
+		os.Exit(-2)
+	}
+	scheduler.SetDrainConcurrency(*drainConcurrency)
+	scheduler.SetMaintenanceLead(*maintenanceLead)
+	scheduler.SetStaging(*stagingDisk, *role, *principal)
+	if err := scheduler.SetDefaultPlacementStrategy(*placementStrategy); err != nil {
+		log.Fatalf("Invalid --placement-strategy: %v\n", err)
+		os.Exit(-2)
+	}
+	if err := scheduler.SetDefaultRecoveryPolicy(*recoveryPolicy); err != nil {
+		log.Fatalf("Invalid --recovery-policy: %v\n", err)
+		os.Exit(-2)
+	}
+
+	// A simulation must not touch the state of a real framework, so it only
+	// persists to a state file that is given explicitly.
+	if *simulate > 0 {
+		stateFileSet := false
+		flag.Visit(func(f *flag.Flag) {
+			stateFileSet = stateFileSet || f.Name == "state-file"
+		})
+		if !stateFileSet {
+			*stateFile = ""
+		} else if *stateFile != "" && filepath.Clean(*stateFile) == filepath.Clean(flag.Lookup("state-file").DefValue) {
+			log.Fatalf("--simulate can't use the default --state-file '%v'\n", *stateFile)
+			os.Exit(-2)
+		}
+	}
+
+	// Recover state of a previous run
+	var frameworkId *mesos.FrameworkID
+	if *stateFile != "" {
+		stateStore, err := store.Open(*stateFile)
+		if err != nil {
+			log.Fatalf("Failed to open state file '%v' with error: %v\n", *stateFile, err)
+			os.Exit(-5)
+		}
+		defer stateStore.Close()
+		frameworkId, err = scheduler.LoadState(stateStore)
+		if err != nil {
+			log.Fatalf("Failed to load state from '%v' with error: %v\n", *stateFile, err)
+			os.Exit(-5)
+		}
+	}
+
+	err = scheduler.SetRebalancer(RebalanceConfig{
+		Interval:  *rebalanceInterval,
+		Threshold: *rebalanceThreshold,
+		Budget:    *rebalanceBudget,
+		Cooldown:  *rebalanceCooldown,
+		DryRun:    *rebalanceDryRun,
+	})
+	if err != nil {
+		log.Fatalf("Invalid rebalancing flags: %v\n", err)
+		os.Exit(-2)
+	}
+
+	if err := scheduler.RegisterMetrics(); err != nil {
+		log.Fatalf("Failed to register metrics: %v\n", err)
+		os.Exit(-2)
+	}
+
 	//Start trigger server
 	go trigger.RunTriggerServer(scheduler)
 
@@ -83,28 +195,139 @@ func main() {
 	fwinfo := &mesos.FrameworkInfo{
 		User: proto.String(""), // Mesos-go will fill in user.
 		Name: proto.String("Test Framework (Go)"),
+		Id:   frameworkId,
+		FailoverTimeout: proto.Float64(*failoverTimeout),
+		Checkpoint: proto.Bool(*checkpoint),
+		Role: proto.String(*role),
+	}
+	if *principal != "" {
+		fwinfo.Principal = proto.String(*principal)
+	}
+	if *hostname != "" {
+		fwinfo.Hostname = proto.String(*hostname)
+	}
+	if *webuiUrl != "" {
+		fwinfo.WebuiUrl = proto.String(*webuiUrl)
 	}
 
-	// Scheduler Driver
-	config := sched.DriverConfig{
-		Scheduler:      scheduler,
-		Framework:      fwinfo,
-		Master:         *master,
-		Credential:     (*mesos.Credential)(nil),
-		BindingAddress: parseIP(*address),
+	if *simulate > 0 {
+		runSimulation(scheduler, fwinfo)
+		return
+	}
+
+	// Credentials
+	credential := (*mesos.Credential)(nil)
+	if *secretFile != "" {
+		if *principal == "" {
+			log.Fatalf("--secret-file needs a --principal\n")
+			os.Exit(-3)
+		}
+		secret, err := ioutil.ReadFile(*secretFile)
+		if err != nil {
+			log.Fatalf("Failed to read secret file '%v' with error: %v\n", *secretFile, err)
+			os.Exit(-3)
+		}
+		credential = &mesos.Credential{
+			Principal: proto.String(*principal),
+			Secret:    proto.String(string(bytes.TrimSpace(secret))),
+		}
 	}
 
-	driver, err := sched.NewMesosSchedulerDriver(config)
+	// Scheduler Driver
+	var driver sched.SchedulerDriver
+	switch *transport {
+	case "libprocess":
+		bindingAddress := parseIP(*address)
+		config := sched.DriverConfig{
+			Scheduler:      scheduler,
+			Framework:      fwinfo,
+			Master:         *master,
+			Credential:     credential,
+			BindingAddress: bindingAddress,
+			HostnameOverride: *hostname,
+			WithAuthContext: func(ctx context.Context) context.Context {
+				ctx = auth.WithLoginProvider(ctx, *authProvider)
+				ctx = sasl.WithBindingAddress(ctx, bindingAddress)
+				return ctx
+			},
+		}
+		driver, err = sched.NewMesosSchedulerDriver(config)
+	case "http":
+		driver, err = httpsched.NewDriver(httpsched.Config{
+			Master:     *master,
+			Scheduler:  scheduler,
+			Framework:  fwinfo,
+			Credential: credential,
+		})
+	default:
+		log.Fatalf("Unknown --transport %q, expected libprocess or http\n", *transport)
+		os.Exit(-2)
+	}
 
 	if err != nil {
 		log.Fatalf("Unable to create a SchedulerDriver: %v\n", err.Error())
 		os.Exit(-3)
 	}
 
-	if stat, err := driver.Run(); err != nil {
+	// The driver doesn't always tell the scheduler that authentication was
+	// rejected, so don't wait for registration forever.
+	if *registrationTimeout > 0 {
+		go func() {
+			if !scheduler.WaitRegistered(*registrationTimeout) {
+				log.Fatalf("Framework did not register within %v. If the master requires authentication, check --principal, --secret-file and the master log\n", *registrationTimeout)
+			}
+		}()
+	}
+
+	stat, err := driver.Run()
+	if err != nil {
 		log.Fatalf("Framework stopped with status %s and error: %s\n", stat.String(), err.Error())
 		os.Exit(-4)
 	}
+	if stat == mesos.Status_DRIVER_ABORTED {
+		log.Fatalf("Framework aborted: %s\n", scheduler.LastError())
+		os.Exit(-4)
+	}
+}
+
+// runSimulation runs the scheduler against a simulated cluster instead of a
+// Mesos master.
+func runSimulation(scheduler *ExampleScheduler, fwinfo *mesos.FrameworkInfo) {
+	attributes, err := simulator.ParseAttributes(*simAttributes)
+	if err != nil {
+		log.Fatalf("Invalid --sim-attributes: %v\n", err)
+		os.Exit(-2)
+	}
+	latencies, err := simulator.ParseLatencies(*simLatencies)
+	if err != nil {
+		log.Fatalf("Invalid --sim-latencies: %v\n", err)
+		os.Exit(-2)
+	}
+	// The simulated master registers the scheduler under an ID of its own.
+	simFwinfo := *fwinfo
+	simFwinfo.Id = nil
+	master, err := simulator.NewMaster(scheduler, &simFwinfo, simulator.Config{
+		Hosts:         *simulate,
+		Cpus:          *simCpus,
+		Mem:           *simMem,
+		Disk:          *simDisk,
+		Attributes:    attributes,
+		OfferInterval: *simOfferInterval,
+		Latency:       *simLatency,
+		Latencies:     latencies,
+		Jitter:        *simJitter,
+		FailureRate:   *simFailureRate,
+		Seed:          *simSeed,
+	})
+	if err != nil {
+		log.Fatalf("Invalid simulation flags: %v\n", err)
+		os.Exit(-2)
+	}
+	stat, err := master.Run()
+	if err != nil {
+		log.Fatalf("Simulation stopped with status %s and error: %s\n", stat.String(), err.Error())
+		os.Exit(-4)
+	}
 }
 
 func prepareExecutorInfo(uri string, cmd string) *mesos.ExecutorInfo {
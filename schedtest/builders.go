@@ -0,0 +1,137 @@
+package schedtest
+
+import (
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+)
+
+// FrameworkId is the framework ID of the offers built here.
+const FrameworkId = "schedtest-framework"
+
+// OfferBuilder builds a mesos.Offer:
+//
+//	offer := schedtest.NewOffer("offer-1", "host-1").Cpus(2).Mem(1024).Attribute("rack", "a").Build()
+type OfferBuilder struct {
+	offer *mesos.Offer
+}
+
+// NewOffer starts an offer of no resources from the agent on host, whose
+// slave ID is "slave-<host>".
+func NewOffer(id string, host string) *OfferBuilder {
+	return &OfferBuilder{offer: util.NewOffer(util.NewOfferID(id), util.NewFrameworkID(FrameworkId), util.NewSlaveID("slave-"+host), host)}
+}
+
+// SlaveId sets the slave ID of the offer.
+func (b *OfferBuilder) SlaveId(id string) *OfferBuilder {
+	b.offer.SlaveId = util.NewSlaveID(id)
+	return b
+}
+
+func (b *OfferBuilder) Cpus(cpus float64) *OfferBuilder {
+	return b.Resource(util.NewScalarResource("cpus", cpus))
+}
+
+func (b *OfferBuilder) Mem(mem float64) *OfferBuilder {
+	return b.Resource(util.NewScalarResource("mem", mem))
+}
+
+func (b *OfferBuilder) Disk(disk float64) *OfferBuilder {
+	return b.Resource(util.NewScalarResource("disk", disk))
+}
+
+// Resource adds any resource, e.g. a reserved one or a persistent volume.
+func (b *OfferBuilder) Resource(resource *mesos.Resource) *OfferBuilder {
+	b.offer.Resources = append(b.offer.Resources, resource)
+	return b
+}
+
+// Attribute adds a text attribute of the agent.
+func (b *OfferBuilder) Attribute(name string, value string) *OfferBuilder {
+	b.offer.Attributes = append(b.offer.Attributes, &mesos.Attribute{
+		Name: proto.String(name),
+		Type: mesos.Value_TEXT.Enum(),
+		Text: &mesos.Value_Text{Value: proto.String(value)},
+	})
+	return b
+}
+
+// ScalarAttribute adds a scalar attribute of the agent.
+func (b *OfferBuilder) ScalarAttribute(name string, value float64) *OfferBuilder {
+	b.offer.Attributes = append(b.offer.Attributes, &mesos.Attribute{
+		Name:   proto.String(name),
+		Type:   mesos.Value_SCALAR.Enum(),
+		Scalar: &mesos.Value_Scalar{Value: proto.Float64(value)},
+	})
+	return b
+}
+
+// Unavailable announces that the agent goes into maintenance at start, for
+// duration. A zero duration leaves the end open.
+func (b *OfferBuilder) Unavailable(start time.Time, duration time.Duration) *OfferBuilder {
+	unavailability := &mesos.Unavailability{
+		Start: &mesos.TimeInfo{Nanoseconds: proto.Int64(start.UnixNano())},
+	}
+	if duration > 0 {
+		unavailability.Duration = &mesos.DurationInfo{Nanoseconds: proto.Int64(int64(duration))}
+	}
+	b.offer.Unavailability = unavailability
+	return b
+}
+
+func (b *OfferBuilder) Build() *mesos.Offer {
+	return b.offer
+}
+
+// StatusBuilder builds a mesos.TaskStatus:
+//
+//	status := schedtest.StatusFor(task, mesos.TaskState_TASK_FAILED).Reason(mesos.TaskStatus_REASON_SLAVE_REMOVED).Build()
+type StatusBuilder struct {
+	status *mesos.TaskStatus
+}
+
+// NewStatus starts a status of a task, as the master sends it during
+// reconciliation: without the task's labels.
+func NewStatus(taskId string, state mesos.TaskState) *StatusBuilder {
+	return &StatusBuilder{status: util.NewTaskStatus(util.NewTaskID(taskId), state)}
+}
+
+// StatusFor starts a status of a launched task, as its executor sends it:
+// from the task's agent and with its labels.
+func StatusFor(task *mesos.TaskInfo, state mesos.TaskState) *StatusBuilder {
+	b := NewStatus(task.GetTaskId().GetValue(), state)
+	b.status.SlaveId = task.SlaveId
+	b.status.Labels = task.Labels
+	return b
+}
+
+func (b *StatusBuilder) Message(message string) *StatusBuilder {
+	b.status.Message = proto.String(message)
+	return b
+}
+
+func (b *StatusBuilder) Reason(reason mesos.TaskStatus_Reason) *StatusBuilder {
+	b.status.Reason = reason.Enum()
+	return b
+}
+
+func (b *StatusBuilder) Source(source mesos.TaskStatus_Source) *StatusBuilder {
+	b.status.Source = source.Enum()
+	return b
+}
+
+func (b *StatusBuilder) SlaveId(id string) *StatusBuilder {
+	b.status.SlaveId = util.NewSlaveID(id)
+	return b
+}
+
+func (b *StatusBuilder) Labels(labels *mesos.Labels) *StatusBuilder {
+	b.status.Labels = labels
+	return b
+}
+
+func (b *StatusBuilder) Build() *mesos.TaskStatus {
+	return b.status
+}
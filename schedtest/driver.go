@@ -0,0 +1,223 @@
+// Package schedtest provides a fake scheduler driver and builders for the
+// messages a master sends, so that the scheduler can be tested without a
+// Mesos master.
+package schedtest
+
+import (
+	"sync"
+
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	sched "github.com/mesos/mesos-go/scheduler"
+)
+
+var _ sched.SchedulerDriver = &Driver{}
+
+// Launch is a LaunchTasks call.
+type Launch struct {
+	OfferIds []string
+	Tasks    []*mesos.TaskInfo
+	Filters  *mesos.Filters
+}
+
+// Accept is an AcceptOffers call.
+type Accept struct {
+	OfferIds   []string
+	Operations []*mesos.Offer_Operation
+	Filters    *mesos.Filters
+}
+
+// Message is a SendFrameworkMessage call.
+type Message struct {
+	ExecutorId string
+	SlaveId    string
+	Data       string
+}
+
+// Driver is a sched.SchedulerDriver that records the calls made to it
+// instead of talking to a master. It is safe for concurrent use. Every call
+// returns Err, which is nil unless a test sets it.
+type Driver struct {
+	lock       sync.Mutex
+	status     mesos.Status
+	launches   []Launch
+	accepts    []Accept
+	declined   []string
+	killed     []string
+	reconciled [][]*mesos.TaskStatus
+	messages   []Message
+	revived    int
+	Err        error
+}
+
+func NewDriver() *Driver {
+	return &Driver{status: mesos.Status_DRIVER_NOT_STARTED}
+}
+
+func offerIds(ids []*mesos.OfferID) []string {
+	values := []string{}
+	for _, id := range ids {
+		values = append(values, id.GetValue())
+	}
+	return values
+}
+
+func (d *Driver) setStatus(status mesos.Status) (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.status = status
+	return d.status, d.Err
+}
+
+func (d *Driver) Start() (mesos.Status, error) {
+	return d.setStatus(mesos.Status_DRIVER_RUNNING)
+}
+
+func (d *Driver) Stop(failover bool) (mesos.Status, error) {
+	return d.setStatus(mesos.Status_DRIVER_STOPPED)
+}
+
+func (d *Driver) Abort() (mesos.Status, error) {
+	return d.setStatus(mesos.Status_DRIVER_ABORTED)
+}
+
+func (d *Driver) Join() (mesos.Status, error) {
+	return d.Status(), d.Err
+}
+
+func (d *Driver) Run() (mesos.Status, error) {
+	return d.Start()
+}
+
+func (d *Driver) RequestResources(requests []*mesos.Request) (mesos.Status, error) {
+	return d.Status(), d.Err
+}
+
+func (d *Driver) AcceptOffers(ids []*mesos.OfferID, operations []*mesos.Offer_Operation, filters *mesos.Filters) (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.accepts = append(d.accepts, Accept{OfferIds: offerIds(ids), Operations: operations, Filters: filters})
+	return d.status, d.Err
+}
+
+func (d *Driver) LaunchTasks(ids []*mesos.OfferID, tasks []*mesos.TaskInfo, filters *mesos.Filters) (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.launches = append(d.launches, Launch{OfferIds: offerIds(ids), Tasks: tasks, Filters: filters})
+	return d.status, d.Err
+}
+
+func (d *Driver) KillTask(taskId *mesos.TaskID) (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.killed = append(d.killed, taskId.GetValue())
+	return d.status, d.Err
+}
+
+func (d *Driver) DeclineOffer(offerId *mesos.OfferID, filters *mesos.Filters) (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.declined = append(d.declined, offerId.GetValue())
+	return d.status, d.Err
+}
+
+func (d *Driver) ReviveOffers() (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.revived++
+	return d.status, d.Err
+}
+
+func (d *Driver) SendFrameworkMessage(executorId *mesos.ExecutorID, slaveId *mesos.SlaveID, data string) (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.messages = append(d.messages, Message{ExecutorId: executorId.GetValue(), SlaveId: slaveId.GetValue(), Data: data})
+	return d.status, d.Err
+}
+
+func (d *Driver) ReconcileTasks(statuses []*mesos.TaskStatus) (mesos.Status, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.reconciled = append(d.reconciled, statuses)
+	return d.status, d.Err
+}
+
+// Status returns the status of the driver.
+func (d *Driver) Status() mesos.Status {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.status
+}
+
+// Launches returns the LaunchTasks calls, oldest first.
+func (d *Driver) Launches() []Launch {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]Launch{}, d.launches...)
+}
+
+// LaunchedTasks returns every task launched, oldest first.
+func (d *Driver) LaunchedTasks() []*mesos.TaskInfo {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tasks := []*mesos.TaskInfo{}
+	for _, launch := range d.launches {
+		tasks = append(tasks, launch.Tasks...)
+	}
+	return tasks
+}
+
+// Accepts returns the AcceptOffers calls, oldest first.
+func (d *Driver) Accepts() []Accept {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]Accept{}, d.accepts...)
+}
+
+// Declined returns the IDs of the declined offers, oldest first.
+func (d *Driver) Declined() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]string{}, d.declined...)
+}
+
+// Killed returns the IDs of the killed tasks, oldest first.
+func (d *Driver) Killed() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]string{}, d.killed...)
+}
+
+// Reconciled returns the statuses of every ReconcileTasks call, oldest
+// first. An implicit reconciliation has no statuses.
+func (d *Driver) Reconciled() [][]*mesos.TaskStatus {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([][]*mesos.TaskStatus{}, d.reconciled...)
+}
+
+// Messages returns the framework messages sent, oldest first.
+func (d *Driver) Messages() []Message {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]Message{}, d.messages...)
+}
+
+// Revived returns how many times offers were revived.
+func (d *Driver) Revived() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.revived
+}
+
+// Reset forgets the calls recorded so far.
+func (d *Driver) Reset() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.launches = nil
+	d.accepts = nil
+	d.declined = nil
+	d.killed = nil
+	d.reconciled = nil
+	d.messages = nil
+	d.revived = 0
+}
@@ -0,0 +1,233 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/robfig/cron"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const schedulesBucket = "schedules"
+
+// CheckpointSchedule snapshots a running container periodically, either
+// Every so often or on a Cron spec (with a leading seconds field, e.g.
+// "0 */15 * * * *"). The executor keeps the last Retention snapshots on the
+// agent; the artifact store always holds the most recent one.
+type CheckpointSchedule struct {
+	ContainerName string
+	Every         time.Duration `json:",omitempty"`
+	Cron          string        `json:",omitempty"`
+	Retention     int
+	LastTick      time.Time
+	LastSnapshot  time.Time
+	Snapshots     int
+	Missed        int
+	Failed        int
+	LastError     string `json:",omitempty"`
+
+	schedule cron.Schedule
+	stop     chan struct{}
+}
+
+// SetCheckpointSchedule starts snapshotting containerName every interval, or
+// on cronSpec if interval is zero. It replaces an earlier schedule of the
+// container.
+func (sched *ExampleScheduler) SetCheckpointSchedule(containerName string, every time.Duration, cronSpec string, retention int) error {
+	if retention < 1 {
+		return fmt.Errorf("retention must be at least 1")
+	}
+	schedule := &CheckpointSchedule{
+		ContainerName: containerName,
+		Every:         every,
+		Cron:          cronSpec,
+		Retention:     retention,
+	}
+	if err := schedule.parse(); err != nil {
+		return err
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	if old, ok := sched.schedules[containerName]; ok {
+		close(old.stop)
+	}
+	sched.startSchedule(schedule)
+	return nil
+}
+
+func (s *CheckpointSchedule) parse() error {
+	switch {
+	case s.Every > 0 && s.Cron != "":
+		return fmt.Errorf("give either an interval or a cron spec, not both")
+	case s.Every > 0:
+		s.schedule = cron.Every(s.Every)
+	case s.Cron != "":
+		schedule, err := cron.Parse(s.Cron)
+		if err != nil {
+			return fmt.Errorf("invalid cron spec %q: %v", s.Cron, err)
+		}
+		s.schedule = schedule
+	default:
+		return fmt.Errorf("a checkpoint schedule needs an interval or a cron spec")
+	}
+	return nil
+}
+
+// startSchedule registers a parsed schedule and starts its timer. The caller
+// must hold sched.lock.
+func (sched *ExampleScheduler) startSchedule(schedule *CheckpointSchedule) {
+	sched.addSchedule(schedule)
+	sched.saveSchedule(schedule)
+	sched.resumeSchedule(schedule)
+}
+
+// addSchedule registers a parsed schedule without starting its timer. The
+// caller must hold sched.lock.
+func (sched *ExampleScheduler) addSchedule(schedule *CheckpointSchedule) {
+	schedule.stop = make(chan struct{})
+	sched.schedules[schedule.ContainerName] = schedule
+}
+
+// resumeSchedule starts the timer of a registered schedule.
+func (sched *ExampleScheduler) resumeSchedule(schedule *CheckpointSchedule) {
+	go sched.runSchedule(schedule.ContainerName, schedule.schedule, schedule.stop)
+}
+
+func (sched *ExampleScheduler) RemoveCheckpointSchedule(containerName string) bool {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	schedule, ok := sched.schedules[containerName]
+	if !ok {
+		return false
+	}
+	close(schedule.stop)
+	delete(sched.schedules, containerName)
+	sched.unpersist(schedulesBucket, containerName)
+	return true
+}
+
+// CheckpointSchedules returns a copy of every schedule, by container name.
+func (sched *ExampleScheduler) CheckpointSchedules() []CheckpointSchedule {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	schedules := []CheckpointSchedule{}
+	for _, schedule := range sched.schedules {
+		schedules = append(schedules, *schedule)
+	}
+	sort.Sort(byContainerName(schedules))
+	return schedules
+}
+
+type byContainerName []CheckpointSchedule
+
+func (s byContainerName) Len() int           { return len(s) }
+func (s byContainerName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byContainerName) Less(i, j int) bool { return s[i].ContainerName < s[j].ContainerName }
+
+func (sched *ExampleScheduler) runSchedule(containerName string, schedule cron.Schedule, stop chan struct{}) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(schedule.Next(now).Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			sched.checkpointTick(containerName)
+		}
+	}
+}
+
+// checkpointTick queues a snapshot of containerName, unless it isn't running
+// or another operation on it is queued or in flight, in which case the tick
+// is counted as missed.
+func (sched *ExampleScheduler) checkpointTick(containerName string) {
+	host, running := sched.GetContainerHost(containerName)
+	busy := running && sched.hasPendingTask(containerName)
+
+	sched.lock.Lock()
+	schedule, ok := sched.schedules[containerName]
+	if !ok {
+		sched.lock.Unlock()
+		return
+	}
+	schedule.LastTick = time.Now()
+	switch {
+	case !running:
+		schedule.Missed++
+		schedule.LastError = "container is not running"
+	case busy:
+		schedule.Missed++
+		schedule.LastError = "another operation is in flight"
+	default:
+		schedule.LastError = ""
+	}
+	retention := schedule.Retention
+	sched.saveSchedule(schedule)
+	sched.lock.Unlock()
+
+	if !running || busy {
+		log.Infof("Skipping scheduled checkpoint of %s: %s", containerName, schedule.LastError)
+		return
+	}
+	log.Infoln("Generating SNAPSHOT_CONTAINER task...")
+	tags := map[string]string{
+		shared.Tags.TASK_TYPE:      shared.TaskTypes.SNAPSHOT_CONTAINER,
+		shared.Tags.CONTAINER_NAME: containerName,
+		shared.Tags.FILESERVER_IP:  sched.ExternalServer,
+		shared.Tags.TARGET_HOST:    host,
+		shared.Tags.RETENTION:      strconv.Itoa(retention),
+	}
+	sched.pushTask(sched.genTask(tags))
+}
+
+// hasPendingTask reports whether a task for containerName is queued or in
+// flight.
+func (sched *ExampleScheduler) hasPendingTask(containerName string) bool {
+	for _, task := range sched.TaskQueue.List() {
+		if name, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME); name == containerName {
+			return true
+		}
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	for _, task := range sched.inFlight {
+		if name, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME); name == containerName {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotDone records the outcome of a scheduled snapshot. err is empty if
+// the snapshot succeeded.
+func (sched *ExampleScheduler) snapshotDone(containerName string, err string) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	schedule, ok := sched.schedules[containerName]
+	if !ok {
+		return
+	}
+	if err != "" {
+		schedule.Failed++
+		schedule.LastError = err
+	} else {
+		schedule.Snapshots++
+		schedule.LastSnapshot = time.Now()
+		schedule.LastError = ""
+	}
+	sched.saveSchedule(schedule)
+}
+
+// saveSchedule persists a schedule. The caller must hold sched.lock.
+func (sched *ExampleScheduler) saveSchedule(schedule *CheckpointSchedule) {
+	if value, err := json.Marshal(schedule); err != nil {
+		log.Errorf("Failed to encode checkpoint schedule of %s: %v", schedule.ContainerName, err)
+	} else {
+		sched.persist(schedulesBucket, schedule.ContainerName, value)
+	}
+}
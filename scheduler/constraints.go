@@ -0,0 +1,268 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const placementBucket = "placement"
+
+// ConstraintOperators are the Marathon-style placement operators.
+var ConstraintOperators = struct {
+	LIKE     string
+	UNLIKE   string
+	CLUSTER  string
+	GROUP_BY string
+	UNIQUE   string
+	MAX_PER  string
+}{
+	LIKE:     "LIKE",
+	UNLIKE:   "UNLIKE",
+	CLUSTER:  "CLUSTER",
+	GROUP_BY: "GROUP_BY",
+	UNIQUE:   "UNIQUE",
+	MAX_PER:  "MAX_PER",
+}
+
+// hostnameField is the constraint field that matches the agent's hostname
+// instead of one of its attributes.
+const hostnameField = "hostname"
+
+// Constraint limits the hosts a container may be placed on. Field is
+// "hostname" or the name of an agent attribute. UNIQUE, CLUSTER, GROUP_BY and
+// MAX_PER are evaluated against the other containers of the same group.
+type Constraint struct {
+	Field    string
+	Operator string
+	Value    string
+	pattern  *regexp.Regexp //compiled Value of LIKE and UNLIKE
+}
+
+// Placement holds the placement constraints and strategy of a container.
+type Placement struct {
+	Group       string
+	Constraints []Constraint
+	Strategy    string `json:",omitempty"` //name of a PlacementStrategy, empty for the default
+}
+
+// ParseConstraint parses "field:OPERATOR[:value]".
+func ParseConstraint(s string) (Constraint, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 {
+		return Constraint{}, fmt.Errorf("constraint %q is not field:OPERATOR[:value]", s)
+	}
+	c := Constraint{Field: parts[0], Operator: strings.ToUpper(parts[1])}
+	if len(parts) == 3 {
+		c.Value = parts[2]
+	}
+	if err := c.compile(); err != nil {
+		return Constraint{}, fmt.Errorf("constraint %q: %v", s, err)
+	}
+	return c, nil
+}
+
+// compile checks the operator and value of c, and compiles the pattern of
+// LIKE and UNLIKE.
+func (c *Constraint) compile() error {
+	switch c.Operator {
+	case ConstraintOperators.LIKE, ConstraintOperators.UNLIKE:
+		pattern, err := regexp.Compile("^(?:" + c.Value + ")$")
+		if err != nil {
+			return err
+		}
+		c.pattern = pattern
+	case ConstraintOperators.MAX_PER:
+		if n, err := strconv.Atoi(c.Value); err != nil || n < 1 {
+			return fmt.Errorf("MAX_PER needs a positive count")
+		}
+	case ConstraintOperators.GROUP_BY:
+		if c.Value != "" {
+			if n, err := strconv.Atoi(c.Value); err != nil || n < 1 {
+				return fmt.Errorf("GROUP_BY takes a positive count")
+			}
+		}
+	case ConstraintOperators.CLUSTER, ConstraintOperators.UNIQUE:
+	default:
+		return fmt.Errorf("unknown operator %s", c.Operator)
+	}
+	return nil
+}
+
+// compile compiles the constraints of p, which may be shared with the
+// caller, into a copy.
+func (p Placement) compile() (Placement, error) {
+	constraints := make([]Constraint, len(p.Constraints))
+	for i, c := range p.Constraints {
+		if err := c.compile(); err != nil {
+			return Placement{}, fmt.Errorf("constraint %q: %v", c.String(), err)
+		}
+		constraints[i] = c
+	}
+	p.Constraints = constraints
+	return p, nil
+}
+
+func (c Constraint) String() string {
+	if c.Value == "" {
+		return c.Field + ":" + c.Operator
+	}
+	return c.Field + ":" + c.Operator + ":" + c.Value
+}
+
+// satisfied reports whether a host whose value for c.Field is value (ok is
+// false if it has none) may take the container. peers are the values of the
+// hosts the other containers of the group are on, known are the values of
+// all known hosts.
+func (c Constraint) satisfied(value string, ok bool, peers []string, known []string) bool {
+	if !ok {
+		return c.Operator == ConstraintOperators.UNLIKE
+	}
+	count := func(v string) int {
+		n := 0
+		for _, peer := range peers {
+			if peer == v {
+				n++
+			}
+		}
+		return n
+	}
+	switch c.Operator {
+	case ConstraintOperators.LIKE:
+		return c.pattern.MatchString(value)
+	case ConstraintOperators.UNLIKE:
+		return !c.pattern.MatchString(value)
+	case ConstraintOperators.CLUSTER:
+		if c.Value != "" {
+			return value == c.Value
+		}
+		return len(peers) == 0 || count(value) == len(peers)
+	case ConstraintOperators.UNIQUE:
+		return count(value) == 0
+	case ConstraintOperators.MAX_PER:
+		max, _ := strconv.Atoi(c.Value)
+		return count(value) < max
+	case ConstraintOperators.GROUP_BY:
+		values := map[string]bool{value: true}
+		for _, v := range known {
+			values[v] = true
+		}
+		if c.Value != "" {
+			if n, _ := strconv.Atoi(c.Value); len(values) < n {
+				// not all groups have been seen yet, only spread over new ones
+				return count(value) == 0
+			}
+		}
+		for v := range values {
+			if count(v) < count(value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// attributeValue renders an agent attribute as a string.
+func attributeValue(attribute *mesos.Attribute) string {
+	switch attribute.GetType() {
+	case mesos.Value_SCALAR:
+		return strconv.FormatFloat(attribute.GetScalar().GetValue(), 'f', -1, 64)
+	case mesos.Value_RANGES:
+		ranges := []string{}
+		for _, r := range attribute.GetRanges().GetRange() {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", r.GetBegin(), r.GetEnd()))
+		}
+		return "[" + strings.Join(ranges, ",") + "]"
+	case mesos.Value_SET:
+		return "{" + strings.Join(attribute.GetSet().GetItem(), ",") + "}"
+	}
+	return attribute.GetText().GetValue()
+}
+
+// SetPlacement sets the group and constraints of containerName. It returns
+// an error if one of the constraints is invalid.
+func (sched *ExampleScheduler) SetPlacement(containerName string, placement Placement) error {
+	placement, err := placement.compile()
+	if err != nil {
+		return err
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.placements[containerName] = placement
+	if value, err := json.Marshal(placement); err != nil {
+		log.Errorf("Failed to encode placement of %s: %v", containerName, err)
+	} else {
+		sched.persist(placementBucket, containerName, value)
+	}
+	return nil
+}
+
+// hostValue returns the value of field for host. The caller must hold
+// sched.lock.
+func (sched *ExampleScheduler) hostValue(host string, field string) (string, bool) {
+	if field == hostnameField {
+		return host, true
+	}
+	value, ok := sched.hostAttributes[host][field]
+	return value, ok
+}
+
+// checkPlacement returns an error if the constraints of containerName rule
+// out host.
+func (sched *ExampleScheduler) checkPlacement(containerName string, host string) error {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	placement, ok := sched.placements[containerName]
+	if !ok || len(placement.Constraints) == 0 {
+		return nil
+	}
+
+	// hosts the other containers of the group are on, or are being placed on.
+	// containers without a group have no peers
+	peerHosts := []string{}
+	if placement.Group != "" {
+		for other, otherHost := range sched.ContainerSlaveMap {
+			if other != containerName && sched.placements[other].Group == placement.Group {
+				peerHosts = append(peerHosts, otherHost)
+			}
+		}
+		for _, task := range sched.inFlight {
+			taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+			if taskType != shared.TaskTypes.RUN_CONTAINER && taskType != shared.TaskTypes.RESTORE_CONTAINER {
+				continue
+			}
+			other, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME)
+			otherHost, err := shared.GetValueFromLabels(task.Labels, shared.Tags.ACCEPTED_HOST)
+			if err == nil && other != containerName && sched.placements[other].Group == placement.Group {
+				peerHosts = append(peerHosts, otherHost)
+			}
+		}
+	}
+
+	for _, c := range placement.Constraints {
+		value, ok := sched.hostValue(host, c.Field)
+		peers := []string{}
+		for _, peerHost := range peerHosts {
+			if v, ok := sched.hostValue(peerHost, c.Field); ok {
+				peers = append(peers, v)
+			}
+		}
+		known := []string{}
+		for _, knownHost := range sched.hosts {
+			if v, ok := sched.hostValue(knownHost, c.Field); ok {
+				known = append(known, v)
+			}
+		}
+		if !c.satisfied(value, ok, peers, known) {
+			return fmt.Errorf("%s on %s violates %v", containerName, host, c)
+		}
+	}
+	return nil
+}
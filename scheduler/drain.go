@@ -0,0 +1,296 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+const (
+	drainsBucket      = "drains"
+	drainPollInterval = 2 * time.Second
+)
+
+// DrainStates are the states of a host drain. A drain is INCOMPLETE when
+// every container has been tried but some could not be moved off the host.
+var DrainStates = struct {
+	DRAINING   string
+	EMPTY      string
+	INCOMPLETE string
+}{
+	DRAINING:   "DRAINING",
+	EMPTY:      "EMPTY",
+	INCOMPLETE: "INCOMPLETE",
+}
+
+// Drain takes a host out of rotation and migrates its containers elsewhere,
+// at most Concurrency at a time.
+type Drain struct {
+	Host        string
+	State       string
+	Concurrency int
+	Started     time.Time
+	Finished    time.Time         `json:",omitempty"`
+	Maintenance bool              `json:",omitempty"` //started for a maintenance window, ends with it
+	Migrations  map[string]string //map of Container name to migration ID
+	Failed      map[string]string //map of Container name to why it could not be moved
+
+	stop chan struct{}
+}
+
+// SetDrainConcurrency sets how many containers a drain migrates at a time,
+// unless the drain asks for another limit.
+func (sched *ExampleScheduler) SetDrainConcurrency(concurrency int) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.drainConcurrency = concurrency
+}
+
+// DrainHost stops new placements on host and migrates every container on it
+// to other hosts, concurrency at a time, or the default limit if concurrency
+// is 0. Draining a host again retries the containers that could not be moved.
+func (sched *ExampleScheduler) DrainHost(host string, concurrency int) error {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	if concurrency == 0 {
+		concurrency = sched.drainConcurrency
+	}
+	if concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+	sched.beginDrain(host, concurrency, false)
+	return nil
+}
+
+// beginDrain replaces any drain of host with a new one. The caller must hold
+// sched.lock.
+func (sched *ExampleScheduler) beginDrain(host string, concurrency int, maintenance bool) {
+	if old, ok := sched.drains[host]; ok {
+		close(old.stop)
+	}
+	drain := &Drain{
+		Host:        host,
+		State:       DrainStates.DRAINING,
+		Concurrency: concurrency,
+		Maintenance: maintenance,
+		Started:     time.Now(),
+		Migrations:  make(map[string]string),
+		Failed:      make(map[string]string),
+	}
+	sched.startDrain(drain)
+	log.Infof("Draining %s, %d migrations at a time", host, concurrency)
+}
+
+// startDrain registers a drain and, unless it is over, starts moving
+// containers. The caller must hold sched.lock.
+func (sched *ExampleScheduler) startDrain(drain *Drain) {
+	sched.addDrain(drain)
+	sched.saveDrain(drain)
+	sched.resumeDrain(drain)
+}
+
+// addDrain registers a drain without starting it. The caller must hold
+// sched.lock.
+func (sched *ExampleScheduler) addDrain(drain *Drain) {
+	drain.stop = make(chan struct{})
+	sched.drains[drain.Host] = drain
+}
+
+// resumeDrain starts moving the containers of a registered drain, unless it
+// is over.
+func (sched *ExampleScheduler) resumeDrain(drain *Drain) {
+	if drain.State == DrainStates.DRAINING {
+		go sched.runDrain(drain.Host, drain.stop)
+	}
+}
+
+// UndrainHost puts host back into rotation. Migrations already started are
+// left to finish.
+func (sched *ExampleScheduler) UndrainHost(host string) bool {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	return sched.endDrain(host)
+}
+
+// endDrain removes the drain of host, if any. The caller must hold
+// sched.lock.
+func (sched *ExampleScheduler) endDrain(host string) bool {
+	drain, ok := sched.drains[host]
+	if !ok {
+		return false
+	}
+	close(drain.stop)
+	delete(sched.drains, host)
+	sched.unpersist(drainsBucket, host)
+	log.Infof("%s is back in rotation", host)
+	return true
+}
+
+func (sched *ExampleScheduler) isDraining(host string) bool {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	_, ok := sched.drains[host]
+	return ok
+}
+
+// GetDrain returns a copy of the drain of host.
+func (sched *ExampleScheduler) GetDrain(host string) (Drain, bool) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	drain, ok := sched.drains[host]
+	if !ok {
+		return Drain{}, false
+	}
+	return *drain, true
+}
+
+// Drains returns a copy of every drain, by host.
+func (sched *ExampleScheduler) Drains() []Drain {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	drains := []Drain{}
+	for _, drain := range sched.drains {
+		drains = append(drains, *drain)
+	}
+	sort.Sort(byHost(drains))
+	return drains
+}
+
+type byHost []Drain
+
+func (d byHost) Len() int           { return len(d) }
+func (d byHost) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byHost) Less(i, j int) bool { return d[i].Host < d[j].Host }
+
+func (sched *ExampleScheduler) runDrain(host string, stop chan struct{}) {
+	for {
+		if done := sched.drainStep(host); done {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+// drainStep starts migrations for containers still on host, up to the
+// drain's concurrency, and reports whether the drain is over.
+func (sched *ExampleScheduler) drainStep(host string) bool {
+	sched.lock.Lock()
+	drain, ok := sched.drains[host]
+	if !ok {
+		sched.lock.Unlock()
+		return true
+	}
+	active := 0
+	for containerName, migrationId := range drain.Migrations {
+		migration := sched.migrations[migrationId]
+		switch {
+		case migration == nil:
+			delete(drain.Migrations, containerName)
+		case migration.State == MigrationStates.FAILED:
+			drain.Failed[containerName] = migration.Error
+			delete(drain.Migrations, containerName)
+		case migration.active():
+			active++
+		default:
+			delete(drain.Migrations, containerName)
+		}
+	}
+	waiting := []string{}
+	for containerName, containerHost := range sched.ContainerSlaveMap {
+		_, migrating := drain.Migrations[containerName]
+		_, failed := drain.Failed[containerName]
+		if containerHost == host && !migrating && !failed {
+			waiting = append(waiting, containerName)
+		}
+	}
+	sort.Strings(waiting)
+	slots := drain.Concurrency - active
+	sched.lock.Unlock()
+
+	for _, containerName := range waiting {
+		if slots <= 0 {
+			break
+		}
+		target, ok := sched.pickRecoveryHost(containerName, host)
+		if !ok {
+			sched.noteDrainFailure(host, containerName, "no host to move it to")
+			continue
+		}
+		migrationId, err := sched.MigrateContainerTask(containerName, target)
+		if err != nil {
+			sched.noteDrainFailure(host, containerName, err.Error())
+			continue
+		}
+		sched.lock.Lock()
+		if drain, ok := sched.drains[host]; ok {
+			drain.Migrations[containerName] = migrationId
+			sched.saveDrain(drain)
+		}
+		sched.lock.Unlock()
+		slots--
+	}
+
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	drain, ok = sched.drains[host]
+	if !ok {
+		return true
+	}
+	if len(drain.Migrations) > 0 {
+		return false
+	}
+	for containerName, containerHost := range sched.ContainerSlaveMap {
+		if _, failed := drain.Failed[containerName]; containerHost == host && !failed {
+			return false
+		}
+	}
+	drain.Finished = time.Now()
+	if len(drain.Failed) > 0 {
+		drain.State = DrainStates.INCOMPLETE
+		log.Errorf("Drain of %s incomplete, could not move: %v", host, drain.Failed)
+	} else {
+		drain.State = DrainStates.EMPTY
+		log.Infof("%s is empty after %v", host, drain.Finished.Sub(drain.Started))
+	}
+	sched.saveDrain(drain)
+	return true
+}
+
+func (sched *ExampleScheduler) noteDrainFailure(host string, containerName string, reason string) {
+	log.Errorf("Cannot move %s off %s: %s", containerName, host, reason)
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	if drain, ok := sched.drains[host]; ok {
+		drain.Failed[containerName] = reason
+		sched.saveDrain(drain)
+	}
+}
+
+// canPlace returns an error if containerName may not be placed on host,
+// because the host is draining, is due for maintenance, or the container's
+// constraints rule it out.
+func (sched *ExampleScheduler) canPlace(containerName string, host string) error {
+	if sched.isDraining(host) {
+		return fmt.Errorf("%s is draining", host)
+	}
+	if window, ok := sched.maintenanceWindow(host); ok {
+		return fmt.Errorf("%s is due for maintenance at %v", host, window.Start)
+	}
+	return sched.checkPlacement(containerName, host)
+}
+
+// saveDrain persists a drain. The caller must hold sched.lock.
+func (sched *ExampleScheduler) saveDrain(drain *Drain) {
+	if value, err := json.Marshal(drain); err != nil {
+		log.Errorf("Failed to encode drain of %s: %v", drain.Host, err)
+	} else {
+		sched.persist(drainsBucket, drain.Host, value)
+	}
+}
@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	eventHistory      = 1000
+	subscriberBacklog = 256
+)
+
+var EventTypes = struct {
+	TASK_STATE        string
+	TASK_PROGRESS     string
+	OFFER_ACCEPTED    string
+	OFFER_DECLINED    string
+	OFFER_RESERVED    string
+	CONTAINER_PLACED  string
+	CONTAINER_REMOVED string
+}{
+	TASK_STATE:        "TASK_STATE",
+	TASK_PROGRESS:     "TASK_PROGRESS",
+	OFFER_ACCEPTED:    "OFFER_ACCEPTED",
+	OFFER_DECLINED:    "OFFER_DECLINED",
+	OFFER_RESERVED:    "OFFER_RESERVED",
+	CONTAINER_PLACED:  "CONTAINER_PLACED",
+	CONTAINER_REMOVED: "CONTAINER_REMOVED",
+}
+
+// Event is a change in the state of the scheduler. Which fields are set
+// depends on its Type.
+type Event struct {
+	Id            uint64
+	Time          time.Time
+	Type          string
+	TaskId        string        `json:",omitempty"`
+	TaskType      string        `json:",omitempty"`
+	ContainerName string        `json:",omitempty"`
+	Host          string        `json:",omitempty"`
+	State         string        `json:",omitempty"`
+	Reason        string        `json:",omitempty"`
+	Message       string        `json:",omitempty"`
+	Phase         string        `json:",omitempty"`
+	Done          bool          `json:",omitempty"`
+	Bytes         int64         `json:",omitempty"`
+	Duration      time.Duration `json:",omitempty"`
+	LogTime       time.Time     `json:",omitempty"`
+	OfferId       string        `json:",omitempty"`
+	TaskIds       []string      `json:",omitempty"`
+}
+
+// EventBus hands events to its subscribers and keeps the latest ones, so
+// that a subscriber that reconnects can catch up.
+type EventBus struct {
+	lock        sync.Mutex
+	seq         uint64
+	history     []Event
+	subscribers map[chan Event]bool
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan Event]bool),
+	}
+}
+
+// Publish numbers an event and hands it to every subscriber. A subscriber
+// that has fallen too far behind is dropped; it can subscribe again from the
+// last event it saw.
+func (b *EventBus) Publish(event Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.seq++
+	event.Id = b.seq
+	event.Time = time.Now()
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistory {
+		b.history = b.history[len(b.history)-eventHistory:]
+	}
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// Subscribe returns the events after the one with ID after, as far as they
+// are still kept, followed by every new event. The channel is closed when
+// cancel is called or the subscriber falls behind.
+func (b *EventBus) Subscribe(after uint64) (events <-chan Event, cancel func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	backlog := []Event{}
+	for _, event := range b.history {
+		if event.Id > after {
+			backlog = append(backlog, event)
+		}
+	}
+	// the whole backlog is replayed, with room for as many new events as
+	// any other subscriber gets
+	ch := make(chan Event, len(backlog)+subscriberBacklog)
+	for _, event := range backlog {
+		ch <- event
+	}
+	b.subscribers[ch] = true
+	return ch, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// taskEvent returns the event for a transition of a task.
+func taskEvent(record *TaskRecord, transition TaskTransition) Event {
+	return Event{
+		Type:          EventTypes.TASK_STATE,
+		TaskId:        record.TaskId,
+		TaskType:      record.TaskType,
+		ContainerName: record.ContainerName,
+		Host:          record.Host,
+		State:         transition.State,
+		Reason:        transition.Reason,
+		Message:       transition.Message,
+	}
+}
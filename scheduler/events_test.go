@@ -0,0 +1,23 @@
+package scheduler
+
+import "testing"
+
+func TestSubscribeReplaysHistory(t *testing.T) {
+	bus := NewEventBus()
+	for i := 0; i < eventHistory; i++ {
+		bus.Publish(Event{Type: EventTypes.TASK_STATE})
+	}
+	events, cancel := bus.Subscribe(10)
+	defer cancel()
+	bus.Publish(Event{Type: EventTypes.OFFER_DECLINED})
+
+	for want := uint64(11); want <= eventHistory+1; want++ {
+		event, ok := <-events
+		if !ok {
+			t.Fatalf("subscription closed before event %d", want)
+		}
+		if event.Id != want {
+			t.Fatalf("got event %d, want %d", event.Id, want)
+		}
+	}
+}
@@ -19,14 +19,19 @@
 package scheduler
 
 import (
+	"fmt"
 	"github.com/gogo/protobuf/proto"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/golang/glog"
 	mesos "github.com/mesos/mesos-go/mesosproto"
-	util "github.com/mesos/mesos-go/mesosutil"
 	sched "github.com/mesos/mesos-go/scheduler"
 	"github.com/emc-cmd/test-framework/shared"
+	"github.com/emc-cmd/test-framework/store"
 )
 
 type ExampleScheduler struct {
@@ -36,13 +41,59 @@ type ExampleScheduler struct {
 	totalTasks    int
 	cpuPerTask    float64
 	memPerTask    float64
-	TaskQueue	[]*mesos.TaskInfo
+	TaskQueue	*TaskQueue
+	Tasks         *TaskTable
+	Events        *EventBus
+	taskIdPrefix  string
+	taskIdSeq     uint64
 	ContainerSlaveMap map[string]string //map of Container name to hostname
 	ExternalServer string
+	inFlight      map[string]*mesos.TaskInfo //launched tasks that have not reached a terminal state
+	reconciling   map[string]bool //in-flight tasks the master has not reported on since registering
+	reconcileRound int
+	failures      []TaskFailure
+	hosts         map[string]string //map of slave ID to hostname, learned from offers
+	recoveryPolicies map[string]string //map of Container name to recovery policy
+	lastCheckpoint map[string]time.Time
+	lostContainers map[string]LostContainer
+	migrations    map[string]*Migration
+	containerResources map[string]ContainerResources
+	placements    map[string]Placement
+	schedules     map[string]*CheckpointSchedule
+	hostAttributes map[string]map[string]string //map of hostname to its attributes, learned from offers
+	hostResources map[string]ContainerResources //map of hostname to the resources of its last offer
+	drains        map[string]*Drain //map of hostname to its drain, while out of rotation
+	drainConcurrency int
+	maintenance   map[string]*MaintenanceWindow //map of hostname to its announced unavailability
+	maintenanceLead time.Duration
+	defaultStrategy string
+	rebalanceConfig RebalanceConfig
+	rebalanceStop chan struct{}
+	lastRebalance *RebalanceReport
+	lastMoved     map[string]time.Time //map of Container name to when the rebalancer last moved it
+	registered    chan struct{} //closed once the framework has registered
+	registerOnce  sync.Once
+	lastError     string
+	driver        sched.SchedulerDriver
+	refuseSeconds float64
+	suppressSeconds float64
+	suppressed    bool //whether offers are being declined because the task queue is empty
+	filteredHosts map[string]time.Time //hosts whose offers were declined, until when
+	stagingDisk   float64
+	stagingRole   string
+	stagingPrincipal string
+	stagingVolumes map[string]bool //agents known to have a staging volume, by slave ID
+	stagingReserved map[string]time.Time //agents a staging volume was requested on, and when
+	offerLock     sync.Mutex
+	defaultRecoveryPolicy string
+	store         *store.Store
+	lock          sync.Mutex
+	queueLock     sync.Mutex
 
 }
 
 func NewExampleScheduler(exec *mesos.ExecutorInfo, taskCount int, cpuPerTask float64, memPerTask float64, ip string) *ExampleScheduler {
+	events := NewEventBus()
 	return &ExampleScheduler{
 		executor:      exec,
 		tasksLaunched: 0,
@@ -51,16 +102,52 @@ func NewExampleScheduler(exec *mesos.ExecutorInfo, taskCount int, cpuPerTask flo
 		cpuPerTask:    cpuPerTask,
 		memPerTask:    memPerTask,
 		ExternalServer: ip,
+		TaskQueue:     NewTaskQueue(),
+		Tasks:         NewTaskTable(events),
+		Events:        events,
+		taskIdPrefix:  strconv.FormatInt(time.Now().UnixNano(), 36),
 		ContainerSlaveMap: make(map[string]string),
+		inFlight:      make(map[string]*mesos.TaskInfo),
+		reconciling:   make(map[string]bool),
+		hosts:         make(map[string]string),
+		recoveryPolicies: make(map[string]string),
+		lastCheckpoint: make(map[string]time.Time),
+		lostContainers: make(map[string]LostContainer),
+		migrations:    make(map[string]*Migration),
+		containerResources: make(map[string]ContainerResources),
+		placements:    make(map[string]Placement),
+		schedules:     make(map[string]*CheckpointSchedule),
+		hostAttributes: make(map[string]map[string]string),
+		hostResources: make(map[string]ContainerResources),
+		lastMoved:     make(map[string]time.Time),
+		registered:    make(chan struct{}),
+		drains:        make(map[string]*Drain),
+		drainConcurrency: 1,
+		maintenance:   make(map[string]*MaintenanceWindow),
+		maintenanceLead: defaultMaintenanceLead,
+		defaultStrategy: PlacementStrategyNames.FIRST_FIT,
+		refuseSeconds: defaultRefuseSeconds,
+		suppressSeconds: defaultSuppressSeconds,
+		filteredHosts: make(map[string]time.Time),
+		stagingVolumes: make(map[string]bool),
+		stagingReserved: make(map[string]time.Time),
+		defaultRecoveryPolicy: RecoveryPolicies.NONE,
 	}
 }
 
 func (sched *ExampleScheduler) Registered(driver sched.SchedulerDriver, frameworkId *mesos.FrameworkID, masterInfo *mesos.MasterInfo) {
 	log.Infoln("Scheduler Registered with Master ", masterInfo)
+	sched.registerOnce.Do(func() { close(sched.registered) })
+	sched.saveFrameworkId(frameworkId)
+	sched.setDriver(driver)
+	sched.reconcileTasks(driver)
 }
 
 func (sched *ExampleScheduler) Reregistered(driver sched.SchedulerDriver, masterInfo *mesos.MasterInfo) {
 	log.Infoln("Scheduler Re-Registered with Master ", masterInfo)
+	sched.registerOnce.Do(func() { close(sched.registered) })
+	sched.setDriver(driver)
+	sched.reconcileTasks(driver)
 }
 
 func (sched *ExampleScheduler) Disconnected(sched.SchedulerDriver) {
@@ -69,92 +156,164 @@ func (sched *ExampleScheduler) Disconnected(sched.SchedulerDriver) {
 
 func (sched *ExampleScheduler) ResourceOffers(driver sched.SchedulerDriver, offers []*mesos.Offer) {
 	logOffers(offers)
+	offersReceived.Add(float64(len(offers)))
 	log.Infof("received some offers, but do I care?")
 
+	usable := []*mesos.Offer{}
 	for _, offer := range offers {
-		remainingCpus := getOfferCpu(offer)
-		remainingMems := getOfferMem(offer)
-
-		var tasks []*mesos.TaskInfo
-		for sched.cpuPerTask <= remainingCpus &&
-		sched.memPerTask <= remainingMems &&
-		len(sched.TaskQueue) > 0 {
-			log.Infof("Launched tasks: "+string(sched.tasksLaunched))
-			log.Infof("Tasks remaining ot be launched: "+string(len(sched.TaskQueue)))
-
-			sched.tasksLaunched++
-
-			task := sched.popTask()
-			taskType, err := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
-			if err != nil{
-				log.Infof("ERROR: Malformed task info, discarding task %v", task)
-				return
-			}
-			targetHost, err := shared.GetValueFromLabels(task.Labels, shared.Tags.TARGET_HOST)
-			if err != nil && taskType != shared.TaskTypes.RUN_CONTAINER {
-				log.Infof("ERROR: Malformed task info, discarding task %v", task)
-				return
-			}
-			containerName, err := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME)
-			if err != nil{
-				log.Infof("ERROR: Malformed task info, discarding task %v", task)
-				return
-			}
+		sched.noteOffer(offer)
+		sched.noteUnavailability(offer.GetHostname(), offer.GetUnavailability())
+		if sched.noteStaging(driver, offer) {
+			offersUsed.Inc()
+		} else {
+			usable = append(usable, offer)
+		}
+	}
+	sched.dropStranded()
+	candidates := sched.newCandidates(usable)
+	// PopMatching holds the queue lock, which declineOffer takes after
+	// offerLock, so the matching must not take offerLock.
+	stagingAgents := sched.stagingAgents()
 
-			foundAMatch := false
-			switch taskType{
-			case shared.TaskTypes.RESTORE_CONTAINER:
-				if _, ok := sched.ContainerSlaveMap[containerName]; ok {
-					log.Infof("ERROR: %s is already running", containerName)
-					return
-				}
-				foundAMatch = true
-				break
-			case shared.TaskTypes.GET_LOGS:
-				if targetHost == offer.GetHostname() {
-					foundAMatch = sched.ContainerSlaveMap[containerName] == targetHost
-				}
-				break
-			case shared.TaskTypes.CHECKPOINT_CONTAINER:
-				if targetHost == offer.GetHostname() {
-					foundAMatch = sched.ContainerSlaveMap[containerName] == targetHost
-				}
-				break
-			case shared.TaskTypes.RESTORE_CONTAINER:
-				if _, ok := sched.ContainerSlaveMap[containerName]; ok {
-					log.Infof("ERROR: %s is already running", containerName)
-					return
-				}
-				if targetHost == offer.GetHostname() {
-					foundAMatch = true
+	for {
+		var fitting []*Candidate
+		task := sched.TaskQueue.PopMatching(func(task *mesos.TaskInfo) bool {
+			fitting = nil
+			for _, c := range candidates {
+				if taskResources(task).fits(c.Cpus, c.Mem, c.Disk) && stagingFits(task, c, stagingAgents) && sched.taskMatchesOffer(task, c.Offer) {
+					fitting = append(fitting, c)
 				}
-				break
-			default:
-				foundAMatch = true
-				break
 			}
-			if foundAMatch {
-				task.SlaveId = offer.SlaveId
-				task.Labels.Labels = append(task.Labels.Labels, shared.CreateLabel(shared.Tags.ACCEPTED_HOST, *offer.Hostname))
-				log.Infof("Prepared task: %s with offer %s for launch\n", task.GetName(), offer.Id.GetValue())
-
-				tasks = append(tasks, task)
-				remainingCpus -= sched.cpuPerTask
-				remainingMems -= sched.memPerTask
-			} else {
-				defer sched.pushTask(task)
+			return len(fitting) > 0
+		})
+		if task == nil {
+			break
+		}
+		c := fitting[sched.strategyFor(task).Pick(task, fitting)]
+		offer := c.Offer
+		sched.tasksLaunched++
+		log.Infof("Launched tasks: %d", sched.tasksLaunched)
+		log.Infof("Tasks remaining to be launched: %d", sched.TaskQueue.Len())
+
+		task.SlaveId = offer.SlaveId
+		task.Labels.Labels = append(task.Labels.Labels, shared.CreateLabel(shared.Tags.ACCEPTED_HOST, *offer.Hostname))
+		log.Infof("Prepared task: %s with offer %s for launch\n", task.GetName(), offer.Id.GetValue())
+
+		place(task, c, candidates)
+		attachStaging(task, c)
+		sched.addInFlight(task)
+		sched.Tasks.Launched(task.GetTaskId().GetValue(), offer.GetHostname())
+	}
+
+	launched := false
+	for _, c := range candidates {
+		offer := c.Offer
+		if len(c.tasks) == 0 {
+			sched.declineOffer(driver, offer)
+			continue
+		}
+		if !launched {
+			sched.saveQueue()
+			launched = true
+		}
+		log.Infoln("Launching ", len(c.tasks), "tasks for offer", offer.Id.GetValue(), "\nSlaveID: ", offer.GetSlaveId(),"SlaveHostname: ", offer.GetHostname())
+		taskIds := []string{}
+		for _, task := range c.tasks {
+			taskIds = append(taskIds, task.GetTaskId().GetValue())
+		}
+		offersUsed.Inc()
+		sched.Events.Publish(Event{Type: EventTypes.OFFER_ACCEPTED, OfferId: offer.Id.GetValue(), Host: offer.GetHostname(), TaskIds: taskIds})
+		driver.LaunchTasks([]*mesos.OfferID{offer.Id}, c.tasks, sched.launchFilters())
+	}
+}
+
+// taskMatchesOffer reports whether a queued task may be launched on the
+// agent behind offer.
+func (sched *ExampleScheduler) taskMatchesOffer(task *mesos.TaskInfo, offer *mesos.Offer) bool {
+	if until := notBefore(task.Labels); time.Now().Before(until) {
+		log.V(1).Infof("Task %s backs off until %v", task.GetName(), until)
+		return false
+	}
+	taskType, err := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+	if err != nil{
+		log.Infof("ERROR: Malformed task info, skipping task %v", task)
+		return false
+	}
+	targetHost, err := shared.GetValueFromLabels(task.Labels, shared.Tags.TARGET_HOST)
+	if err != nil && taskType != shared.TaskTypes.RUN_CONTAINER && taskType != shared.TaskTypes.TEST_TASK && taskType != shared.TaskTypes.RESTORE_CONTAINER {
+		log.Infof("ERROR: Malformed task info, skipping task %v", task)
+		return false
+	}
+	containerName, err := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME)
+	if err != nil{
+		log.Infof("ERROR: Malformed task info, skipping task %v", task)
+		return false
+	}
+
+	switch taskType{
+	case shared.TaskTypes.GET_LOGS, shared.TaskTypes.CHECKPOINT_CONTAINER, shared.TaskTypes.SNAPSHOT_CONTAINER:
+		host, _ := sched.GetContainerHost(containerName)
+		return targetHost == offer.GetHostname() && host == targetHost
+	case shared.TaskTypes.RESTORE_CONTAINER:
+		if _, ok := sched.GetContainerHost(containerName); ok {
+			log.Infof("%s is still running, holding back restore", containerName)
+			return false
+		}
+		if targetHost == "" {
+			// no target, the placement strategy picks among the offers
+			if err := sched.canPlace(containerName, offer.GetHostname()); err != nil {
+				log.V(1).Infof("Not restoring on %s: %v", offer.GetHostname(), err)
+				return false
 			}
+			return true
 		}
-		log.Infoln("Launching ", len(tasks), "tasks for offer", offer.Id.GetValue(), "\nSlaveID: ", offer.GetSlaveId(),"SlaveHostname: ", offer.GetHostname())
-		driver.LaunchTasks([]*mesos.OfferID{offer.Id}, tasks, &mesos.Filters{RefuseSeconds: proto.Float64(1)})
+		return targetHost == offer.GetHostname()
+	case shared.TaskTypes.RUN_CONTAINER:
+		if err := sched.canPlace(containerName, offer.GetHostname()); err != nil {
+			log.V(1).Infof("Not placing on %s: %v", offer.GetHostname(), err)
+			return false
+		}
+		return true
+	default:
+		return true
 	}
 }
 
 func (sched *ExampleScheduler) StatusUpdate(driver sched.SchedulerDriver, status *mesos.TaskStatus) {
 	log.Infoln("Status update: task", status.TaskId.GetValue(), " is in state ", status.State.Enum().String())
+	sched.Tasks.Update(status)
+	task := sched.getInFlight(status.TaskId.GetValue())
+	sched.trackStatus(status, task)
+	if isTerminalState(status.GetState()) {
+		sched.removeInFlight(status.TaskId.GetValue())
+		if task != nil {
+			taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+			tasksFinished.WithLabelValues(taskType, status.GetState().String()).Inc()
+		}
+	}
+	// statuses from reconciliation carry no labels, the launched task does
+	labels := status.GetLabels()
+	if task != nil {
+		labels = task.Labels
+	}
+	if isFailedState(status.GetState()) {
+		if labels == nil {
+			log.Infof("ERROR: Unknown task failed with status: %v", status)
+			return
+		}
+		sched.handleFailure(status, labels)
+		return
+	}
+	if status.GetState() == mesos.TaskState_TASK_KILLED {
+		sched.failMigration(labels, "task was killed")
+		if taskType, _ := shared.GetValueFromLabels(labels, shared.Tags.TASK_TYPE); taskType == shared.TaskTypes.SNAPSHOT_CONTAINER {
+			containerName, _ := shared.GetValueFromLabels(labels, shared.Tags.CONTAINER_NAME)
+			sched.snapshotDone(containerName, "task was killed")
+		}
+		return
+	}
 	//if RunContainer finished, add
 	if status.State.Enum().String() == "TASK_FINISHED" {
-		labels := status.GetLabels()
 		taskType, err := shared.GetValueFromLabels(labels, shared.Tags.TASK_TYPE)
 		if err != nil{
 			log.Infof("ERROR: Malformed task info, discarding task with status: %v", status)
@@ -172,15 +331,21 @@ func (sched *ExampleScheduler) StatusUpdate(driver sched.SchedulerDriver, status
 		}
 		switch taskType {
 		case shared.TaskTypes.RUN_CONTAINER:
-			sched.ContainerSlaveMap[containerName] = acceptedHost
+			sched.setContainerHost(containerName, acceptedHost)
 			break
 		case shared.TaskTypes.CHECKPOINT_CONTAINER:
-			delete(sched.ContainerSlaveMap, containerName)
+			sched.deleteContainer(containerName)
+			sched.recordCheckpoint(containerName)
 			break
 		case shared.TaskTypes.RESTORE_CONTAINER:
-			sched.ContainerSlaveMap[containerName] = acceptedHost
+			sched.setContainerHost(containerName, acceptedHost)
+			break
+		case shared.TaskTypes.SNAPSHOT_CONTAINER:
+			sched.recordCheckpoint(containerName)
+			sched.snapshotDone(containerName, "")
 			break
 		}
+		sched.advanceMigration(taskType, labels)
 	}
 }
 
@@ -189,19 +354,64 @@ func (sched *ExampleScheduler) OfferRescinded(s sched.SchedulerDriver, id *mesos
 }
 
 func (sched *ExampleScheduler) FrameworkMessage(s sched.SchedulerDriver, exId *mesos.ExecutorID, slvId *mesos.SlaveID, msg string) {
-	log.Infof("Received framework message from executor '%v' on slave '%v': %s.\n", *exId, *slvId, msg)
+	progress, err := shared.DecodeProgress(msg)
+	if err != nil {
+		log.Infof("Received framework message from executor '%v' on slave '%v': %s.\n", *exId, *slvId, msg)
+		return
+	}
+	log.V(1).Infof("Task %s: %s done=%v bytes=%d in %v", progress.TaskId, progress.Phase, progress.Done, progress.Bytes, progress.Duration)
+	observeProgress(progress)
+	if !sched.Tasks.Progress(progress) {
+		log.Infof("Progress reported for unknown task %s", progress.TaskId)
+	}
 }
 
 func (sched *ExampleScheduler) SlaveLost(s sched.SchedulerDriver, id *mesos.SlaveID) {
 	log.Infof("Slave '%v' lost.\n", *id)
+	sched.forgetStaging(id.GetValue())
+	host, ok := sched.forgetSlave(id.GetValue())
+	if !ok {
+		log.Infof("ERROR: Hostname of lost slave '%v' is unknown, cannot recover its containers", id.GetValue())
+		return
+	}
+	sched.handleHostLost(host)
 }
 
+// Containers are run by the docker daemon, not by the executor, so they
+// survive an executor crash. Tasks the executor was running are reported
+// through StatusUpdate.
 func (sched *ExampleScheduler) ExecutorLost(s sched.SchedulerDriver, exId *mesos.ExecutorID, slvId *mesos.SlaveID, i int) {
 	log.Infof("Executor '%v' lost on slave '%v' with exit code: %v.\n", *exId, *slvId, i)
 }
 
 func (sched *ExampleScheduler) Error(driver sched.SchedulerDriver, err string) {
-	log.Infoln("Scheduler received error:", err)
+	log.Errorln("Scheduler received error:", err)
+	lower := strings.ToLower(err)
+	if strings.Contains(lower, "authenticat") || strings.Contains(lower, "authoriz") {
+		log.Errorln("The master rejected the framework's credentials or role; check the principal, secret file and role flags")
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.lastError = err
+}
+
+// WaitRegistered waits up to timeout for the framework to register with a
+// master, and reports whether it did.
+func (sched *ExampleScheduler) WaitRegistered(timeout time.Duration) bool {
+	select {
+	case <-sched.registered:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// LastError returns the last error the driver reported, after which it
+// aborted.
+func (sched *ExampleScheduler) LastError() string {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	return sched.lastError
 }
 
 func (sched *ExampleScheduler) TestTask(containerID string) {
@@ -216,9 +426,9 @@ func (sched *ExampleScheduler) TestTask(containerID string) {
 }
 
 func (sched *ExampleScheduler) RunContainerTask(containerName string) {
-	if val, ok := sched.ContainerSlaveMap[containerName]; ok {
+	if val, ok := sched.GetContainerHost(containerName); ok {
 		msg := containerName+" has already been launched on "+val
-		log.Infof(msg)
+		log.Infoln(msg)
 		return
 	}
 	log.Infoln("Generating RUN_CONTAINER task...")
@@ -232,9 +442,10 @@ func (sched *ExampleScheduler) RunContainerTask(containerName string) {
 }
 
 func (sched *ExampleScheduler) CheckpointContainerTask(containerName string) {
-	if _, ok := sched.ContainerSlaveMap[containerName]; !ok {
+	host, ok := sched.GetContainerHost(containerName)
+	if !ok {
 		msg := containerName+" has not been launched yet!"
-		log.Infof(msg)
+		log.Infoln(msg)
 		return
 	}
 	log.Infoln("Generating CHECKPOINT_CONTAINER task...")
@@ -242,28 +453,56 @@ func (sched *ExampleScheduler) CheckpointContainerTask(containerName string) {
 		shared.Tags.TASK_TYPE : shared.TaskTypes.CHECKPOINT_CONTAINER,
 		shared.Tags.CONTAINER_NAME: containerName,
 		shared.Tags.FILESERVER_IP: sched.ExternalServer,
-		shared.Tags.TARGET_HOST: sched.ContainerSlaveMap[containerName],
+		shared.Tags.TARGET_HOST: host,
 	}
 	task := sched.genTask(tags)
 	sched.pushTask(task)
 }
 
+// RestoreContainerTask restores containerName on targetHost, or on the host
+// its placement strategy picks from the offers if targetHost is empty.
 func (sched *ExampleScheduler) RestoreContainerTask(containerName string, targetHost string) {
+	sched.queueRestore(containerName, targetHost, "")
+}
+
+// RestoreContainerTaskWithStrategy queues an untargeted restore of
+// containerName that the named strategy places, whatever the container's own.
+func (sched *ExampleScheduler) RestoreContainerTaskWithStrategy(containerName string, strategy string) error {
+	if _, ok := PlacementStrategies[strategy]; !ok {
+		return fmt.Errorf("unknown placement strategy %q", strategy)
+	}
+	sched.queueRestore(containerName, "", strategy)
+	return nil
+}
+
+func (sched *ExampleScheduler) queueRestore(containerName string, targetHost string, strategy string) {
+	if targetHost != "" {
+		if err := sched.canPlace(containerName, targetHost); err != nil {
+			log.Infof("ERROR: Not restoring: %v", err)
+			return
+		}
+	}
 	log.Infoln("Generating RESTORE_CONTAINER task...")
 	tags := map[string]string{
 		shared.Tags.TASK_TYPE : shared.TaskTypes.RESTORE_CONTAINER,
 		shared.Tags.CONTAINER_NAME: containerName,
 		shared.Tags.FILESERVER_IP: sched.ExternalServer,
-		shared.Tags.TARGET_HOST: targetHost,
+	}
+	if targetHost != "" {
+		tags[shared.Tags.TARGET_HOST] = targetHost
+	}
+	if strategy != "" {
+		tags[shared.Tags.STRATEGY] = strategy
 	}
 	task := sched.genTask(tags)
 	sched.pushTask(task)
 }
 
 func (sched *ExampleScheduler) GetLogsTask(containerName string) {
-	if _, ok := sched.ContainerSlaveMap[containerName]; !ok {
+	host, ok := sched.GetContainerHost(containerName)
+	if !ok {
 		msg := containerName+" has not been launched yet!"
-		log.Infof(msg)
+		log.Infoln(msg)
 		return
 	}
 	log.Infoln("Generating GET_LOGS task...")
@@ -271,25 +510,62 @@ func (sched *ExampleScheduler) GetLogsTask(containerName string) {
 		shared.Tags.TASK_TYPE : shared.TaskTypes.GET_LOGS,
 		shared.Tags.CONTAINER_NAME: containerName,
 		shared.Tags.FILESERVER_IP: sched.ExternalServer,
-		shared.Tags.TARGET_HOST: sched.ContainerSlaveMap[containerName],
+		shared.Tags.TARGET_HOST: host,
 	}
 	task := sched.genTask(tags)
 	sched.pushTask(task)
 }
 
 func (sched *ExampleScheduler) pushTask(task *mesos.TaskInfo) {
-	sched.TaskQueue = append(sched.TaskQueue, task)
+	sched.Tasks.Add(task)
+	sched.TaskQueue.Push(task)
+	sched.saveQueue()
+	sched.reviveOffersFor(task)
 }
 
-func (sched *ExampleScheduler) popTask() *mesos.TaskInfo{
-	task := sched.TaskQueue[len(sched.TaskQueue)-1]
-	sched.TaskQueue = sched.TaskQueue[:len(sched.TaskQueue)-1]
-	return task
+// newTaskId returns an ID no other task of this framework has: the start
+// time of the scheduler process followed by a sequence number.
+func (sched *ExampleScheduler) newTaskId() string {
+	return fmt.Sprintf("%s-%d", sched.taskIdPrefix, atomic.AddUint64(&sched.taskIdSeq, 1))
+}
+
+// CancelTask removes a queued task before it is launched. It returns false if
+// the task is not in the queue. Cancelling a task of a migration fails the
+// migration.
+func (sched *ExampleScheduler) CancelTask(taskId string) bool {
+	task := sched.TaskQueue.Remove(taskId)
+	if task == nil {
+		return false
+	}
+	sched.saveQueue()
+	sched.Tasks.Cancelled(taskId)
+	sched.failMigration(task.Labels, "task was cancelled")
+	log.Infof("Cancelled queued task %s", task.GetName())
+	return true
+}
+
+// KillTask asks Mesos to kill a launched task. The executor aborts the step
+// the task is in and reports TASK_KILLED, which fails the task's migration.
+func (sched *ExampleScheduler) KillTask(taskId string) error {
+	if sched.getInFlight(taskId) == nil {
+		return fmt.Errorf("task %s is not running", taskId)
+	}
+	sched.offerLock.Lock()
+	driver := sched.driver
+	sched.offerLock.Unlock()
+	if driver == nil {
+		return fmt.Errorf("the scheduler is not registered with a master")
+	}
+	if _, err := driver.KillTask(&mesos.TaskID{Value: proto.String(taskId)}); err != nil {
+		return fmt.Errorf("failed to kill task %s: %v", taskId, err)
+	}
+	log.Infof("Asked to kill task %s", taskId)
+	return nil
 }
 
 func (sched *ExampleScheduler) genTask(tags map[string]string) *mesos.TaskInfo {
 	taskId := &mesos.TaskID{
-		Value: proto.String(strconv.Itoa(sched.tasksLaunched)),
+		Value: proto.String(sched.newTaskId()),
 	}
 	labels := &mesos.Labels{
 		Labels: []*mesos.Label{
@@ -298,16 +574,13 @@ func (sched *ExampleScheduler) genTask(tags map[string]string) *mesos.TaskInfo {
 	for key, value := range tags {
 		log.Infoln("Tag being processed: "+key+" : "+value)
 		labels.Labels = append(labels.Labels, shared.CreateLabel(key, value))
-		log.Infoln("Current tags: %v", labels)
+		log.Infof("Current tags: %v", labels)
 	}
 	task := &mesos.TaskInfo{
 		Name:     proto.String("go-task-" + taskId.GetValue()),
 		TaskId:   taskId,
 		Executor: sched.executor,
-		Resources: []*mesos.Resource{
-			util.NewScalarResource("cpus", sched.cpuPerTask),
-			util.NewScalarResource("mem", sched.memPerTask),
-		},
+		Resources: sched.resourcesFor(tags[shared.Tags.CONTAINER_NAME]).toMesos(),
 		Labels: labels,
 	}
 	return task
@@ -0,0 +1,531 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	"github.com/emc-cmd/test-framework/schedtest"
+	"github.com/emc-cmd/test-framework/shared"
+	"github.com/emc-cmd/test-framework/store"
+)
+
+const (
+	testContainer = "web"
+	testMigration = "migration"
+)
+
+func newTestScheduler() (*ExampleScheduler, *schedtest.Driver) {
+	exec := util.NewExecutorInfo(util.NewExecutorID("default"), util.NewCommandInfo("./executor"))
+	return NewExampleScheduler(exec, 0, 0.5, 128, "http://fileserver"), schedtest.NewDriver()
+}
+
+func testOffer(id string, host string) *schedtest.OfferBuilder {
+	return schedtest.NewOffer(id, host).Cpus(4).Mem(4096).Disk(10240)
+}
+
+// queueTestTask queues a task of taskType for testContainer, pinned to
+// targetHost unless it is empty.
+func queueTestTask(sched *ExampleScheduler, taskType string, targetHost string, attempt int) {
+	tags := map[string]string{
+		shared.Tags.TASK_TYPE:      taskType,
+		shared.Tags.CONTAINER_NAME: testContainer,
+		shared.Tags.FILESERVER_IP:  sched.ExternalServer,
+	}
+	if targetHost != "" {
+		tags[shared.Tags.TARGET_HOST] = targetHost
+	}
+	if attempt > 0 {
+		tags[shared.Tags.ATTEMPT] = strconv.Itoa(attempt)
+	}
+	sched.pushTask(sched.genTask(tags))
+}
+
+// roleResource returns name reserved for role, as offered to a framework
+// registered with that role.
+func roleResource(name string, value float64, role string) *mesos.Resource {
+	resource := util.NewScalarResource(name, value)
+	resource.Role = &role
+	return resource
+}
+
+func drainHost(host string) func(*ExampleScheduler) {
+	return func(sched *ExampleScheduler) {
+		sched.drains[host] = &Drain{Host: host, State: DrainStates.DRAINING}
+	}
+}
+
+func runContainer(containerName string, host string) func(*ExampleScheduler) {
+	return func(sched *ExampleScheduler) {
+		sched.setContainerHost(containerName, host)
+	}
+}
+
+// restoring starts a migration of testContainer to host-a that has reached
+// its restore.
+func restoring(sched *ExampleScheduler) {
+	sched.migrations[testMigration] = &Migration{
+		Id:            testMigration,
+		ContainerName: testContainer,
+		SourceHost:    "host-b",
+		TargetHost:    "host-a",
+		State:         MigrationStates.RESTORING,
+		Started:       time.Now(),
+	}
+}
+
+func TestResourceOffersMatchesHosts(t *testing.T) {
+	types := shared.TaskTypes
+	tests := []struct {
+		name       string
+		taskType   string
+		targetHost string
+		setup      func(*ExampleScheduler)
+		offer      *schedtest.OfferBuilder
+		launch     bool
+	}{
+		{"run on any host", types.RUN_CONTAINER, "", nil, testOffer("o", "host-a"), true},
+		{"run on draining host", types.RUN_CONTAINER, "", drainHost("host-a"), testOffer("o", "host-a"), false},
+		{"run on host due for maintenance", types.RUN_CONTAINER, "", nil,
+			testOffer("o", "host-a").Unavailable(time.Now().Add(48*time.Hour), time.Hour), false},
+		{"run against constraint", types.RUN_CONTAINER, "", func(sched *ExampleScheduler) {
+			sched.SetPlacement(testContainer, Placement{Constraints: []Constraint{{Field: "rack", Operator: ConstraintOperators.LIKE, Value: "b"}}})
+		}, testOffer("o", "host-a").Attribute("rack", "a"), false},
+		{"run within constraint", types.RUN_CONTAINER, "", func(sched *ExampleScheduler) {
+			sched.SetPlacement(testContainer, Placement{Constraints: []Constraint{{Field: "rack", Operator: ConstraintOperators.LIKE, Value: "b"}}})
+		}, testOffer("o", "host-a").Attribute("rack", "b"), true},
+		{"run on too small offer", types.RUN_CONTAINER, "", nil, schedtest.NewOffer("o", "host-a").Cpus(0.1).Mem(4096), false},
+		{"test task on any host", types.TEST_TASK, "", nil, testOffer("o", "host-a"), true},
+		{"test task on draining host", types.TEST_TASK, "", drainHost("host-a"), testOffer("o", "host-a"), true},
+		{"get logs on container's host", types.GET_LOGS, "host-a", runContainer(testContainer, "host-a"), testOffer("o", "host-a"), true},
+		{"get logs on other host", types.GET_LOGS, "host-a", runContainer(testContainer, "host-a"), testOffer("o", "host-b"), false},
+		{"get logs of moved container", types.GET_LOGS, "host-a", runContainer(testContainer, "host-b"), testOffer("o", "host-a"), false},
+		{"checkpoint on container's host", types.CHECKPOINT_CONTAINER, "host-a", runContainer(testContainer, "host-a"), testOffer("o", "host-a"), true},
+		{"checkpoint on draining container's host", types.CHECKPOINT_CONTAINER, "host-a",
+			func(sched *ExampleScheduler) { runContainer(testContainer, "host-a")(sched); drainHost("host-a")(sched) }, testOffer("o", "host-a"), true},
+		{"checkpoint on other host", types.CHECKPOINT_CONTAINER, "host-a", runContainer(testContainer, "host-a"), testOffer("o", "host-b"), false},
+		{"checkpoint of stopped container", types.CHECKPOINT_CONTAINER, "host-a", nil, testOffer("o", "host-a"), false},
+		{"snapshot on container's host", types.SNAPSHOT_CONTAINER, "host-a", runContainer(testContainer, "host-a"), testOffer("o", "host-a"), true},
+		{"snapshot on other host", types.SNAPSHOT_CONTAINER, "host-a", runContainer(testContainer, "host-a"), testOffer("o", "host-b"), false},
+		{"restore on target host", types.RESTORE_CONTAINER, "host-b", nil, testOffer("o", "host-b"), true},
+		{"restore on other host", types.RESTORE_CONTAINER, "host-b", nil, testOffer("o", "host-a"), false},
+		{"restore of running container", types.RESTORE_CONTAINER, "host-b", runContainer(testContainer, "host-a"), testOffer("o", "host-b"), false},
+		{"restore without target", types.RESTORE_CONTAINER, "", nil, testOffer("o", "host-a"), true},
+		{"restore without target on draining host", types.RESTORE_CONTAINER, "", drainHost("host-a"), testOffer("o", "host-a"), false},
+		{"test task on cpus reserved for a role", types.TEST_TASK, "", nil,
+			schedtest.NewOffer("o", "host-a").Mem(4096).Disk(10240).Resource(roleResource("cpus", 4, "ops")), false},
+	}
+	for _, tt := range tests {
+		sched, driver := newTestScheduler()
+		if tt.setup != nil {
+			tt.setup(sched)
+		}
+		queueTestTask(sched, tt.taskType, tt.targetHost, 0)
+		offer := tt.offer.Build()
+		sched.ResourceOffers(driver, []*mesos.Offer{offer})
+
+		launched := driver.LaunchedTasks()
+		if !tt.launch {
+			if len(launched) != 0 || !reflect.DeepEqual(driver.Declined(), []string{"o"}) {
+				t.Errorf("%s: launched %d tasks and declined %v, want the offer declined", tt.name, len(launched), driver.Declined())
+			}
+			if sched.TaskQueue.Len() != 1 {
+				t.Errorf("%s: %d tasks queued, want the task left in the queue", tt.name, sched.TaskQueue.Len())
+			}
+			continue
+		}
+		if len(launched) != 1 || len(driver.Declined()) != 0 {
+			t.Errorf("%s: launched %d tasks and declined %v, want the task launched", tt.name, len(launched), driver.Declined())
+			continue
+		}
+		task := launched[0]
+		if host, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.ACCEPTED_HOST); host != offer.GetHostname() {
+			t.Errorf("%s: accepted host is %q, want %q", tt.name, host, offer.GetHostname())
+		}
+		if task.GetSlaveId().GetValue() != offer.GetSlaveId().GetValue() {
+			t.Errorf("%s: launched on slave %q, want %q", tt.name, task.GetSlaveId().GetValue(), offer.GetSlaveId().GetValue())
+		}
+		if sched.getInFlight(task.GetTaskId().GetValue()) == nil {
+			t.Errorf("%s: launched task is not in flight", tt.name)
+		}
+		if record, _ := sched.Tasks.Get(task.GetTaskId().GetValue()); record.State != TaskRecordStates.LAUNCHED {
+			t.Errorf("%s: task is %s, want %s", tt.name, record.State, TaskRecordStates.LAUNCHED)
+		}
+	}
+}
+
+func TestResourceOffersPrefersHighPriority(t *testing.T) {
+	sched, driver := newTestScheduler()
+	sched.setContainerHost(testContainer, "host-a")
+	queueTestTask(sched, shared.TaskTypes.TEST_TASK, "", 0)
+	queueTestTask(sched, shared.TaskTypes.CHECKPOINT_CONTAINER, "host-a", 0)
+	sched.ResourceOffers(driver, []*mesos.Offer{schedtest.NewOffer("o", "host-a").Cpus(0.5).Mem(128).Build()})
+
+	launched := driver.LaunchedTasks()
+	if len(launched) != 1 {
+		t.Fatalf("launched %d tasks, want 1", len(launched))
+	}
+	if taskType, _ := shared.GetValueFromLabels(launched[0].Labels, shared.Tags.TASK_TYPE); taskType != shared.TaskTypes.CHECKPOINT_CONTAINER {
+		t.Errorf("launched %s, want the checkpoint first", taskType)
+	}
+}
+
+func TestStatusUpdateTransitions(t *testing.T) {
+	types := shared.TaskTypes
+	lost := mesos.TaskStatus_REASON_SLAVE_REMOVED
+	tests := []struct {
+		name           string
+		taskType       string
+		attempt        int
+		setup          func(*ExampleScheduler)
+		state          mesos.TaskState
+		reason         *mesos.TaskStatus_Reason
+		wantContainers map[string]string
+		wantInFlight   bool
+		wantFailures   int
+		wantCheckpoint bool
+		wantQueued     int
+		wantMigration  string // state of the task's migration, "" if it is not part of one
+	}{
+		{"run running", types.RUN_CONTAINER, 0, nil, mesos.TaskState_TASK_RUNNING, nil,
+			map[string]string{}, true, 0, false, 0, ""},
+		{"run finished", types.RUN_CONTAINER, 0, nil, mesos.TaskState_TASK_FINISHED, nil,
+			map[string]string{testContainer: "host-a"}, false, 0, false, 0, ""},
+		{"run failed, retried", types.RUN_CONTAINER, 1, nil, mesos.TaskState_TASK_FAILED, nil,
+			map[string]string{}, false, 0, false, 1, ""},
+		{"run failed for good", types.RUN_CONTAINER, 3, nil, mesos.TaskState_TASK_FAILED, nil,
+			map[string]string{}, false, 1, false, 0, ""},
+		{"run error", types.RUN_CONTAINER, 1, nil, mesos.TaskState_TASK_ERROR, nil,
+			map[string]string{}, false, 1, false, 0, ""},
+		{"run lost with agent", types.RUN_CONTAINER, 3, runContainer("other", "host-a"), mesos.TaskState_TASK_LOST, &lost,
+			map[string]string{}, false, 1, false, 0, ""},
+		{"run lost with agent, attempts left", types.RUN_CONTAINER, 1, nil, mesos.TaskState_TASK_LOST, &lost,
+			map[string]string{}, false, 1, false, 0, ""},
+		{"run killed", types.RUN_CONTAINER, 0, nil, mesos.TaskState_TASK_KILLED, nil,
+			map[string]string{}, false, 0, false, 0, ""},
+		{"checkpoint running", types.CHECKPOINT_CONTAINER, 0, runContainer(testContainer, "host-a"), mesos.TaskState_TASK_RUNNING, nil,
+			map[string]string{testContainer: "host-a"}, true, 0, false, 0, ""},
+		{"checkpoint finished", types.CHECKPOINT_CONTAINER, 0, runContainer(testContainer, "host-a"), mesos.TaskState_TASK_FINISHED, nil,
+			map[string]string{}, false, 0, true, 0, ""},
+		{"checkpoint killed", types.CHECKPOINT_CONTAINER, 0, runContainer(testContainer, "host-a"), mesos.TaskState_TASK_KILLED, nil,
+			map[string]string{testContainer: "host-a"}, false, 0, false, 0, ""},
+		{"checkpoint failed for good", types.CHECKPOINT_CONTAINER, 3, runContainer(testContainer, "host-a"), mesos.TaskState_TASK_FAILED, nil,
+			map[string]string{testContainer: "host-a"}, false, 1, false, 0, ""},
+		{"restore finished", types.RESTORE_CONTAINER, 0, nil, mesos.TaskState_TASK_FINISHED, nil,
+			map[string]string{testContainer: "host-a"}, false, 0, false, 0, ""},
+		{"restore failed for good", types.RESTORE_CONTAINER, 5, nil, mesos.TaskState_TASK_FAILED, nil,
+			map[string]string{}, false, 1, false, 0, ""},
+		{"restore killed during a migration", types.RESTORE_CONTAINER, 0, restoring, mesos.TaskState_TASK_KILLED, nil,
+			map[string]string{}, false, 0, false, 0, MigrationStates.FAILED},
+		{"restore lost during a migration, retried", types.RESTORE_CONTAINER, 1, restoring, mesos.TaskState_TASK_LOST, nil,
+			map[string]string{}, false, 0, false, 1, MigrationStates.RESTORING},
+		{"restore lost with agent during a migration", types.RESTORE_CONTAINER, 1, restoring, mesos.TaskState_TASK_LOST, &lost,
+			map[string]string{}, false, 1, false, 0, MigrationStates.FAILED},
+		{"snapshot finished", types.SNAPSHOT_CONTAINER, 0, runContainer(testContainer, "host-a"), mesos.TaskState_TASK_FINISHED, nil,
+			map[string]string{testContainer: "host-a"}, false, 0, true, 0, ""},
+		{"snapshot failed", types.SNAPSHOT_CONTAINER, 0, runContainer(testContainer, "host-a"), mesos.TaskState_TASK_FAILED, nil,
+			map[string]string{testContainer: "host-a"}, false, 1, false, 0, ""},
+		{"get logs finished", types.GET_LOGS, 0, runContainer(testContainer, "host-a"), mesos.TaskState_TASK_FINISHED, nil,
+			map[string]string{testContainer: "host-a"}, false, 0, false, 0, ""},
+		{"get logs failed, retried", types.GET_LOGS, 1, runContainer(testContainer, "host-a"), mesos.TaskState_TASK_FAILED, nil,
+			map[string]string{testContainer: "host-a"}, false, 0, false, 1, ""},
+		{"get logs failed for good", types.GET_LOGS, 2, runContainer(testContainer, "host-a"), mesos.TaskState_TASK_FAILED, nil,
+			map[string]string{testContainer: "host-a"}, false, 1, false, 0, ""},
+		{"test task finished", types.TEST_TASK, 0, nil, mesos.TaskState_TASK_FINISHED, nil,
+			map[string]string{}, false, 0, false, 0, ""},
+		{"test task failed", types.TEST_TASK, 0, nil, mesos.TaskState_TASK_FAILED, nil,
+			map[string]string{}, false, 1, false, 0, ""},
+	}
+	for _, tt := range tests {
+		sched, driver := newTestScheduler()
+		if tt.setup != nil {
+			tt.setup(sched)
+		}
+		targetHost := ""
+		switch tt.taskType {
+		case types.CHECKPOINT_CONTAINER, types.SNAPSHOT_CONTAINER, types.GET_LOGS, types.RESTORE_CONTAINER:
+			targetHost = "host-a"
+		}
+		queueTestTask(sched, tt.taskType, targetHost, tt.attempt)
+		if tt.wantMigration != "" {
+			queued := sched.TaskQueue.List()[0]
+			queued.Labels.Labels = append(queued.Labels.Labels, shared.CreateLabel(shared.Tags.MIGRATION_ID, testMigration))
+		}
+		sched.ResourceOffers(driver, []*mesos.Offer{testOffer("o", "host-a").Build()})
+		launched := driver.LaunchedTasks()
+		if len(launched) != 1 {
+			t.Errorf("%s: launched %d tasks, want 1", tt.name, len(launched))
+			continue
+		}
+		task := launched[0]
+		status := schedtest.StatusFor(task, tt.state)
+		if tt.reason != nil {
+			status.Reason(*tt.reason)
+		}
+		sched.StatusUpdate(driver, status.Build())
+
+		if !reflect.DeepEqual(sched.ContainerSlaveMap, tt.wantContainers) {
+			t.Errorf("%s: containers are %v, want %v", tt.name, sched.ContainerSlaveMap, tt.wantContainers)
+		}
+		if inFlight := sched.getInFlight(task.GetTaskId().GetValue()) != nil; inFlight != tt.wantInFlight {
+			t.Errorf("%s: in flight is %v, want %v", tt.name, inFlight, tt.wantInFlight)
+		}
+		if failures := sched.Failures(""); len(failures) != tt.wantFailures {
+			t.Errorf("%s: %d failures recorded, want %d", tt.name, len(failures), tt.wantFailures)
+		}
+		if _, checkpointed := sched.lastCheckpoint[testContainer]; checkpointed != tt.wantCheckpoint {
+			t.Errorf("%s: checkpoint recorded is %v, want %v", tt.name, checkpointed, tt.wantCheckpoint)
+		}
+		if queued := sched.TaskQueue.Len(); queued != tt.wantQueued {
+			t.Errorf("%s: %d tasks queued, want %d", tt.name, queued, tt.wantQueued)
+		}
+		if migration, _ := sched.GetMigration(testMigration); migration.State != tt.wantMigration {
+			t.Errorf("%s: migration is %q, want %q", tt.name, migration.State, tt.wantMigration)
+		}
+		if record, _ := sched.Tasks.Get(task.GetTaskId().GetValue()); record.State != tt.state.String() {
+			t.Errorf("%s: task is %s, want %s", tt.name, record.State, tt.state.String())
+		}
+	}
+}
+
+func TestStatusUpdateOfUnknownTask(t *testing.T) {
+	sched, driver := newTestScheduler()
+	sched.setContainerHost(testContainer, "host-a")
+	for _, state := range []mesos.TaskState{mesos.TaskState_TASK_RUNNING, mesos.TaskState_TASK_LOST, mesos.TaskState_TASK_FINISHED} {
+		sched.StatusUpdate(driver, schedtest.NewStatus("unknown", state).Build())
+	}
+	if host, _ := sched.GetContainerHost(testContainer); host != "host-a" {
+		t.Errorf("container moved to %q, want it left on host-a", host)
+	}
+	if failures := sched.Failures(""); len(failures) != 0 {
+		t.Errorf("%d failures recorded, want none", len(failures))
+	}
+}
+
+func TestStatusUpdateAdvancesMigration(t *testing.T) {
+	sched, driver := newTestScheduler()
+	sched.setContainerHost(testContainer, "host-a")
+	migrationId, err := sched.MigrateContainerTask(testContainer, "host-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sched.ResourceOffers(driver, []*mesos.Offer{testOffer("o1", "host-a").Build()})
+	launched := driver.LaunchedTasks()
+	if len(launched) != 1 {
+		t.Fatalf("launched %d tasks, want the checkpoint", len(launched))
+	}
+	sched.StatusUpdate(driver, schedtest.StatusFor(launched[0], mesos.TaskState_TASK_FINISHED).Build())
+	if migration, _ := sched.GetMigration(migrationId); migration.State != MigrationStates.RESTORING {
+		t.Fatalf("migration is %s, want %s", migration.State, MigrationStates.RESTORING)
+	}
+	if _, ok := sched.GetContainerHost(testContainer); ok {
+		t.Errorf("checkpointed container is still mapped")
+	}
+
+	driver.Reset()
+	sched.ResourceOffers(driver, []*mesos.Offer{testOffer("o2", "host-a").Build(), testOffer("o3", "host-b").Build()})
+	launched = driver.LaunchedTasks()
+	if len(launched) != 1 || !reflect.DeepEqual(driver.Declined(), []string{"o2"}) {
+		t.Fatalf("launched %d tasks and declined %v, want the restore launched on host-b", len(launched), driver.Declined())
+	}
+	sched.StatusUpdate(driver, schedtest.StatusFor(launched[0], mesos.TaskState_TASK_FINISHED).Build())
+	if migration, _ := sched.GetMigration(migrationId); migration.State != MigrationStates.DONE {
+		t.Errorf("migration is %s, want %s", migration.State, MigrationStates.DONE)
+	}
+	if host, _ := sched.GetContainerHost(testContainer); host != "host-b" {
+		t.Errorf("container is on %q, want host-b", host)
+	}
+}
+
+func TestKillTask(t *testing.T) {
+	sched, driver := newTestScheduler()
+	sched.setDriver(driver)
+	queueTestTask(sched, shared.TaskTypes.RUN_CONTAINER, "", 0)
+	queued := sched.TaskQueue.List()[0].GetTaskId().GetValue()
+	if err := sched.KillTask(queued); err == nil {
+		t.Errorf("killed queued task %s, want it to be cancelled instead", queued)
+	}
+
+	sched.ResourceOffers(driver, []*mesos.Offer{testOffer("o1", "host-a").Build()})
+	if err := sched.KillTask(queued); err != nil {
+		t.Fatal(err)
+	}
+	if killed := driver.Killed(); !reflect.DeepEqual(killed, []string{queued}) {
+		t.Errorf("killed %v, want %s", killed, queued)
+	}
+}
+
+func TestCancelTaskFailsMigration(t *testing.T) {
+	sched, _ := newTestScheduler()
+	sched.setContainerHost(testContainer, "host-a")
+	migrationId, err := sched.MigrateContainerTask(testContainer, "host-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := sched.TaskQueue.List()[0].GetTaskId().GetValue()
+	if !sched.CancelTask(checkpoint) {
+		t.Fatalf("checkpoint %s is not queued", checkpoint)
+	}
+	if migration, _ := sched.GetMigration(migrationId); migration.State != MigrationStates.FAILED {
+		t.Errorf("migration is %s, want %s", migration.State, MigrationStates.FAILED)
+	}
+	if record, _ := sched.Tasks.Get(checkpoint); record.State != TaskRecordStates.CANCELLED {
+		t.Errorf("checkpoint is %s, want %s", record.State, TaskRecordStates.CANCELLED)
+	}
+}
+
+func TestFailedTaskBacksOffInQueue(t *testing.T) {
+	sched, driver := newTestScheduler()
+	queueTestTask(sched, shared.TaskTypes.RUN_CONTAINER, "", 1)
+	sched.ResourceOffers(driver, []*mesos.Offer{testOffer("o1", "host-a").Build()})
+	failed := driver.LaunchedTasks()[0]
+	sched.StatusUpdate(driver, schedtest.StatusFor(failed, mesos.TaskState_TASK_FAILED).Build())
+
+	queued := sched.TaskQueue.List()
+	if len(queued) != 1 {
+		t.Fatalf("%d tasks queued after a failure, want the retry", len(queued))
+	}
+	retry := queued[0].GetTaskId().GetValue()
+	if attempt := taskAttempt(queued[0].Labels); attempt != 2 {
+		t.Errorf("retry is attempt %d, want 2", attempt)
+	}
+	if record, ok := sched.Tasks.Get(retry); !ok || record.State != TaskRecordStates.QUEUED {
+		t.Errorf("retry is recorded as %+v, want %s", record, TaskRecordStates.QUEUED)
+	}
+	driver.Reset()
+	sched.ResourceOffers(driver, []*mesos.Offer{testOffer("o2", "host-a").Build()})
+	if launched := driver.LaunchedTasks(); len(launched) != 0 {
+		t.Errorf("launched %d tasks during the backoff, want none", len(launched))
+	}
+	if !sched.CancelTask(retry) {
+		t.Errorf("retry %s can't be cancelled during its backoff", retry)
+	}
+}
+
+func TestStrandedTasksAreGivenUp(t *testing.T) {
+	sched, driver := newTestScheduler()
+	// a checkpoint retry of a container that has left its host, and a task
+	// without a type
+	queueTestTask(sched, shared.TaskTypes.CHECKPOINT_CONTAINER, "host-a", 2)
+	sched.pushTask(sched.genTask(map[string]string{shared.Tags.CONTAINER_NAME: testContainer}))
+	// a first checkpoint keeps waiting for its container
+	queueTestTask(sched, shared.TaskTypes.CHECKPOINT_CONTAINER, "host-a", 0)
+	sched.ResourceOffers(driver, []*mesos.Offer{testOffer("o", "host-a").Build()})
+
+	if sched.TaskQueue.Len() != 1 {
+		t.Errorf("%d tasks queued, want only the first checkpoint left", sched.TaskQueue.Len())
+	}
+	if failures := sched.Failures(testContainer); len(failures) != 2 {
+		t.Errorf("recorded %d failures, want 2: %+v", len(failures), failures)
+	}
+}
+
+func TestLoadStateRestoresTasks(t *testing.T) {
+	s, err := store.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+	sched, driver := newTestScheduler()
+	if _, err := sched.LoadState(s); err != nil {
+		t.Fatalf("load empty state: %v", err)
+	}
+	queueTestTask(sched, shared.TaskTypes.TEST_TASK, "", 0)
+	sched.ResourceOffers(driver, []*mesos.Offer{testOffer("o", "host-a").Build()})
+	queueTestTask(sched, shared.TaskTypes.RESTORE_CONTAINER, "host-b", 0)
+	queueTestTask(sched, shared.TaskTypes.GET_LOGS, "host-b", 2)
+
+	restarted, _ := newTestScheduler()
+	if _, err := restarted.LoadState(s); err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if got, want := restarted.TaskQueue.List(), sched.TaskQueue.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored queue %v, want %v", got, want)
+	}
+	launched := driver.LaunchedTasks()[0]
+	if got := restarted.getInFlight(launched.GetTaskId().GetValue()); !reflect.DeepEqual(got, launched) {
+		t.Errorf("restored in-flight task %v, want %v", got, launched)
+	}
+}
+
+func TestSetPlacementRejectsInvalidConstraints(t *testing.T) {
+	sched, _ := newTestScheduler()
+	invalid := []Constraint{
+		{Field: "rack", Operator: ConstraintOperators.LIKE, Value: "("},
+		{Field: "rack", Operator: ConstraintOperators.MAX_PER, Value: "0"},
+		{Field: "rack", Operator: "NEAR"},
+	}
+	for _, c := range invalid {
+		if err := sched.SetPlacement(testContainer, Placement{Constraints: []Constraint{c}}); err == nil {
+			t.Errorf("set placement with %v, want an error", c)
+		}
+	}
+	if _, ok := sched.placements[testContainer]; ok {
+		t.Errorf("invalid placement was set: %+v", sched.placements[testContainer])
+	}
+}
+
+func TestUngroupedContainersAreNotPeers(t *testing.T) {
+	sched, _ := newTestScheduler()
+	unique := Placement{Constraints: []Constraint{{Field: "hostname", Operator: ConstraintOperators.UNIQUE}}}
+	for _, containerName := range []string{testContainer, "db"} {
+		if err := sched.SetPlacement(containerName, unique); err != nil {
+			t.Fatalf("set placement of %s: %v", containerName, err)
+		}
+	}
+	sched.setContainerHost("db", "host1")
+	if err := sched.checkPlacement(testContainer, "host1"); err != nil {
+		t.Errorf("ungrouped containers constrained each other: %v", err)
+	}
+}
+
+func TestRestoreStrategyIsPerRequest(t *testing.T) {
+	sched, _ := newTestScheduler()
+	if err := sched.SetPlacement(testContainer, Placement{Strategy: PlacementStrategyNames.SPREAD}); err != nil {
+		t.Fatalf("set placement: %v", err)
+	}
+	if err := sched.RestoreContainerTaskWithStrategy(testContainer, "nearest"); err == nil {
+		t.Errorf("restore with an unknown strategy, want an error")
+	}
+	if err := sched.RestoreContainerTaskWithStrategy(testContainer, PlacementStrategyNames.BIN_PACK); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if sched.TaskQueue.Len() != 1 {
+		t.Fatalf("queued %d tasks, want 1", sched.TaskQueue.Len())
+	}
+	if got := sched.strategyFor(sched.TaskQueue.List()[0]); got != PlacementStrategies[PlacementStrategyNames.BIN_PACK] {
+		t.Errorf("restore placed by %T, want BinPack", got)
+	}
+	if got := sched.placements[testContainer].Strategy; got != PlacementStrategyNames.SPREAD {
+		t.Errorf("container strategy is %q after the restore, want %q", got, PlacementStrategyNames.SPREAD)
+	}
+}
+
+func TestAgentLossIsRecoveredNotRetried(t *testing.T) {
+	sched, driver := newTestScheduler()
+	sched.setContainerHost(testContainer, "host-a")
+	if err := sched.SetRecoveryPolicy(testContainer, RecoveryPolicies.RESTART_FRESH); err != nil {
+		t.Fatal(err)
+	}
+	queueTestTask(sched, shared.TaskTypes.CHECKPOINT_CONTAINER, "host-a", 1)
+	sched.ResourceOffers(driver, []*mesos.Offer{testOffer("o1", "host-a").Build()})
+	checkpoint := driver.LaunchedTasks()[0]
+	sched.StatusUpdate(driver, schedtest.StatusFor(checkpoint, mesos.TaskState_TASK_LOST).
+		Reason(mesos.TaskStatus_REASON_SLAVE_REMOVED).Build())
+
+	queued := sched.TaskQueue.List()
+	if len(queued) != 1 {
+		t.Fatalf("%d tasks queued after the agent was lost, want only the recovery", len(queued))
+	}
+	if taskType, _ := shared.GetValueFromLabels(queued[0].Labels, shared.Tags.TASK_TYPE); taskType != shared.TaskTypes.RUN_CONTAINER {
+		t.Errorf("queued a %s task, want the %s of the recovery", taskType, shared.TaskTypes.RUN_CONTAINER)
+	}
+	if failures := sched.Failures(testContainer); len(failures) != 1 {
+		t.Errorf("%d failures recorded, want the lost checkpoint", len(failures))
+	}
+}
@@ -0,0 +1,199 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	sched "github.com/mesos/mesos-go/scheduler"
+)
+
+const (
+	maintenanceBucket      = "maintenance"
+	defaultMaintenanceLead = time.Hour
+)
+
+// The master announces maintenance of an agent by attaching its
+// unavailability to the agent's offers and by sending inverse offers, which
+// only the HTTP driver passes on. The scheduler stops placing
+// containers on a host as soon as it learns of a window, starts evacuating
+// the host's containers ahead of it, and puts the host back into rotation
+// once the window is over or cancelled.
+
+// MaintenanceWindow is a period during which a host is unavailable. A zero
+// Duration means the host is unavailable indefinitely from Start.
+type MaintenanceWindow struct {
+	Host     string
+	Start    time.Time
+	Duration time.Duration `json:",omitempty"`
+
+	timer *time.Timer
+}
+
+// End returns when the window is over, or false if it never is.
+func (w *MaintenanceWindow) End() (time.Time, bool) {
+	if w.Duration == 0 {
+		return time.Time{}, false
+	}
+	return w.Start.Add(w.Duration), true
+}
+
+func (w *MaintenanceWindow) over(now time.Time) bool {
+	end, ok := w.End()
+	return ok && !now.Before(end)
+}
+
+// SetMaintenanceLead sets how long before a maintenance window the scheduler
+// starts evacuating the host.
+func (sched *ExampleScheduler) SetMaintenanceLead(lead time.Duration) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.maintenanceLead = lead
+}
+
+// noteUnavailability updates the maintenance window of host from one of its
+// offers. A nil unavailability means no maintenance is scheduled.
+func (sched *ExampleScheduler) noteUnavailability(host string, unavailability *mesos.Unavailability) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	old, known := sched.maintenance[host]
+	if unavailability == nil {
+		if known {
+			log.Infof("Maintenance of %s was cancelled", host)
+			sched.endMaintenance(host)
+		}
+		return
+	}
+	window := &MaintenanceWindow{
+		Host:     host,
+		Start:    time.Unix(0, unavailability.GetStart().GetNanoseconds()),
+		Duration: time.Duration(unavailability.GetDuration().GetNanoseconds()),
+	}
+	if window.over(time.Now()) {
+		if known {
+			sched.endMaintenance(host)
+		}
+		return
+	}
+	if known && old.Start.Equal(window.Start) && old.Duration == window.Duration {
+		return
+	}
+	if known {
+		old.timer.Stop()
+	}
+	log.Infof("%s is due for maintenance at %v", host, window.Start)
+	sched.startMaintenance(window)
+}
+
+// InverseOffers notes the maintenance windows the master announces through
+// inverse offers. The offers aren't answered; the scheduler evacuates the
+// hosts on its own schedule.
+func (sched *ExampleScheduler) InverseOffers(driver sched.SchedulerDriver, offers []*mesos.InverseOffer) {
+	for _, offer := range offers {
+		sched.lock.Lock()
+		host, ok := sched.hosts[offer.GetSlaveId().GetValue()]
+		sched.lock.Unlock()
+		if !ok {
+			log.Infof("Inverse offer %s is for unknown slave %s", offer.GetId().GetValue(), offer.GetSlaveId().GetValue())
+			continue
+		}
+		sched.noteUnavailability(host, offer.GetUnavailability())
+	}
+}
+
+// startMaintenance registers a window and arms a timer to evacuate the host
+// ahead of it. The caller must hold sched.lock.
+func (sched *ExampleScheduler) startMaintenance(window *MaintenanceWindow) {
+	sched.maintenance[window.Host] = window
+	sched.saveMaintenance(window)
+	sched.resumeMaintenance(window)
+}
+
+// resumeMaintenance arms the evacuation timer of a registered window. The
+// caller must hold sched.lock.
+func (sched *ExampleScheduler) resumeMaintenance(window *MaintenanceWindow) {
+	host := window.Host
+	window.timer = time.AfterFunc(window.Start.Add(-sched.maintenanceLead).Sub(time.Now()), func() {
+		sched.evacuate(host)
+	})
+}
+
+// endMaintenance forgets the window of host and, if the scheduler drained the
+// host for it, puts the host back into rotation. The caller must hold
+// sched.lock.
+func (sched *ExampleScheduler) endMaintenance(host string) {
+	if window, ok := sched.maintenance[host]; ok {
+		window.timer.Stop()
+		delete(sched.maintenance, host)
+		sched.unpersist(maintenanceBucket, host)
+	}
+	if drain, ok := sched.drains[host]; ok && drain.Maintenance {
+		sched.endDrain(host)
+	}
+}
+
+// evacuate drains host ahead of its maintenance window, and arms a timer to
+// put it back into rotation once the window is over.
+func (sched *ExampleScheduler) evacuate(host string) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	window, ok := sched.maintenance[host]
+	if !ok {
+		return
+	}
+	if _, draining := sched.drains[host]; !draining {
+		log.Infof("Evacuating %s ahead of maintenance at %v", host, window.Start)
+		sched.beginDrain(host, sched.drainConcurrency, true)
+	}
+	if end, ok := window.End(); ok {
+		window.timer = time.AfterFunc(end.Sub(time.Now()), func() {
+			sched.lock.Lock()
+			defer sched.lock.Unlock()
+			if sched.maintenance[host] == window {
+				log.Infof("Maintenance of %s is over", host)
+				sched.endMaintenance(host)
+			}
+		})
+	}
+}
+
+// maintenanceWindow returns the window of host, if it has one that isn't
+// over.
+func (sched *ExampleScheduler) maintenanceWindow(host string) (MaintenanceWindow, bool) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	window, ok := sched.maintenance[host]
+	if !ok || window.over(time.Now()) {
+		return MaintenanceWindow{}, false
+	}
+	return *window, true
+}
+
+// MaintenanceWindows returns a copy of every known window, by host.
+func (sched *ExampleScheduler) MaintenanceWindows() []MaintenanceWindow {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	windows := []MaintenanceWindow{}
+	for _, window := range sched.maintenance {
+		windows = append(windows, *window)
+	}
+	sort.Sort(byWindowHost(windows))
+	return windows
+}
+
+type byWindowHost []MaintenanceWindow
+
+func (w byWindowHost) Len() int           { return len(w) }
+func (w byWindowHost) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
+func (w byWindowHost) Less(i, j int) bool { return w[i].Host < w[j].Host }
+
+// saveMaintenance persists a window. The caller must hold sched.lock.
+func (sched *ExampleScheduler) saveMaintenance(window *MaintenanceWindow) {
+	if value, err := json.Marshal(window); err != nil {
+		log.Errorf("Failed to encode maintenance window of %s: %v", window.Host, err)
+	} else {
+		sched.persist(maintenanceBucket, window.Host, value)
+	}
+}
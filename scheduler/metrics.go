@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const metricsNamespace = "test_framework"
+
+var (
+	offersReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "offers_received_total",
+		Help:      "Offers received from the master.",
+	})
+	offersDeclined = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "offers_declined_total",
+		Help:      "Offers declined because no queued task could use them.",
+	})
+	offersUsed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "offers_used_total",
+		Help:      "Offers used to launch tasks or reserve staging volumes.",
+	})
+	tasksFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tasks_finished_total",
+		Help:      "Tasks that reached a terminal state, by task type and state.",
+	}, []string{"task_type", "state"})
+	migrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "migrations_total",
+		Help:      "Migrations that ended, by final state.",
+	}, []string{"state"})
+	migrationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "migration_duration_seconds",
+		Help:      "Time taken by successful migrations, by phase: checkpoint, restore and total.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"phase"})
+	executorPhaseSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "executor",
+		Name:      "phase_duration_seconds",
+		Help:      "Time the executor took for each phase of a checkpoint or restore, docker commands included.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"phase"})
+	checkpointImageBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "executor",
+		Name:      "checkpoint_image_bytes",
+		Help:      "Size of archived checkpoint images.",
+		Buckets:   prometheus.ExponentialBuckets(1<<20, 2, 12),
+	})
+	transferBytesPerSecond = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "executor",
+		Name:      "transfer_bytes_per_second",
+		Help:      "Throughput of checkpoint image uploads and downloads.",
+		Buckets:   prometheus.ExponentialBuckets(1<<16, 2, 14),
+	}, []string{"direction"})
+)
+
+func init() {
+	prometheus.MustRegister(offersReceived, offersDeclined, offersUsed, tasksFinished, migrations,
+		migrationSeconds, executorPhaseSeconds, checkpointImageBytes, transferBytesPerSecond)
+}
+
+// observeProgress records the metrics of a phase the executor finished.
+func observeProgress(progress shared.Progress) {
+	if !progress.Done || !progress.LogTime.IsZero() {
+		return
+	}
+	executorPhaseSeconds.WithLabelValues(progress.Phase).Observe(progress.Duration.Seconds())
+	switch progress.Phase {
+	case shared.ProgressPhases.ARCHIVING:
+		checkpointImageBytes.Observe(float64(progress.Bytes))
+	case shared.ProgressPhases.UPLOADING, shared.ProgressPhases.DOWNLOADING:
+		if progress.Duration > 0 {
+			direction := "upload"
+			if progress.Phase == shared.ProgressPhases.DOWNLOADING {
+				direction = "download"
+			}
+			transferBytesPerSecond.WithLabelValues(direction).Observe(float64(progress.Bytes) / progress.Duration.Seconds())
+		}
+	}
+}
+
+// observeMigration records the metrics of a migration that ended.
+func observeMigration(migration Migration) {
+	migrations.WithLabelValues(migration.State).Inc()
+	if migration.State != MigrationStates.DONE {
+		return
+	}
+	migrationSeconds.WithLabelValues("checkpoint").Observe(migration.CheckpointTime.Seconds())
+	migrationSeconds.WithLabelValues("restore").Observe(migration.RestoreTime.Seconds())
+	migrationSeconds.WithLabelValues("total").Observe(migration.TotalTime.Seconds())
+}
+
+var (
+	queueDepthDesc = prometheus.NewDesc(metricsNamespace+"_queue_depth",
+		"Queued tasks, by task type.", []string{"task_type"}, nil)
+	inFlightDesc = prometheus.NewDesc(metricsNamespace+"_tasks_in_flight",
+		"Launched tasks that haven't reached a terminal state, by task type.", []string{"task_type"}, nil)
+	containersDesc = prometheus.NewDesc(metricsNamespace+"_containers",
+		"Running containers, by host.", []string{"host"}, nil)
+)
+
+// schedulerCollector reports the state of a scheduler when scraped.
+type schedulerCollector struct {
+	sched *ExampleScheduler
+}
+
+// RegisterMetrics adds the queue, in-flight tasks and containers of sched to
+// the metrics served on /metrics.
+func (sched *ExampleScheduler) RegisterMetrics() error {
+	return prometheus.Register(schedulerCollector{sched})
+}
+
+func (c schedulerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- inFlightDesc
+	ch <- containersDesc
+}
+
+func (c schedulerCollector) Collect(ch chan<- prometheus.Metric) {
+	queued := map[string]int{}
+	for _, task := range c.sched.TaskQueue.List() {
+		taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+		queued[taskType]++
+	}
+	for taskType, count := range queued {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(count), taskType)
+	}
+
+	c.sched.lock.Lock()
+	inFlight := map[string]int{}
+	for _, task := range c.sched.inFlight {
+		taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+		inFlight[taskType]++
+	}
+	containers := map[string]int{}
+	for _, host := range c.sched.ContainerSlaveMap {
+		containers[host]++
+	}
+	c.sched.lock.Unlock()
+
+	for taskType, count := range inFlight {
+		ch <- prometheus.MustNewConstMetric(inFlightDesc, prometheus.GaugeValue, float64(count), taskType)
+	}
+	for host, count := range containers {
+		ch <- prometheus.MustNewConstMetric(containersDesc, prometheus.GaugeValue, float64(count), host)
+	}
+}
@@ -0,0 +1,205 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const migrationsBucket = "migrations"
+
+// MigrationStates are the states of a migration. A migration checkpoints the
+// container on its current host, and only once that checkpoint has finished
+// restores it on the target host.
+var MigrationStates = struct {
+	CHECKPOINTING string
+	RESTORING     string
+	DONE          string
+	FAILED        string
+}{
+	CHECKPOINTING: "CHECKPOINTING",
+	RESTORING:     "RESTORING",
+	DONE:          "DONE",
+	FAILED:        "FAILED",
+}
+
+type Migration struct {
+	Id             string
+	ContainerName  string
+	SourceHost     string
+	TargetHost     string
+	State          string
+	Started        time.Time
+	Checkpointed   time.Time
+	Finished       time.Time
+	CheckpointTime time.Duration
+	RestoreTime    time.Duration
+	TotalTime      time.Duration
+	Error          string
+}
+
+func (m *Migration) active() bool {
+	return m.State == MigrationStates.CHECKPOINTING || m.State == MigrationStates.RESTORING
+}
+
+// MigrateContainerTask moves a running container to targetHost. It returns
+// the ID of the migration, which can be followed with GetMigration.
+func (sched *ExampleScheduler) MigrateContainerTask(containerName string, targetHost string) (string, error) {
+	sourceHost, ok := sched.GetContainerHost(containerName)
+	if !ok {
+		return "", fmt.Errorf("%s has not been launched yet", containerName)
+	}
+	if sourceHost == targetHost {
+		return "", fmt.Errorf("%s is already running on %s", containerName, targetHost)
+	}
+	if err := sched.canPlace(containerName, targetHost); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	migration := &Migration{
+		Id:            fmt.Sprintf("migrate-%s-%d", containerName, now.UnixNano()),
+		ContainerName: containerName,
+		SourceHost:    sourceHost,
+		TargetHost:    targetHost,
+		State:         MigrationStates.CHECKPOINTING,
+		Started:       now,
+	}
+	sched.lock.Lock()
+	for _, other := range sched.migrations {
+		if other.ContainerName == containerName && other.active() {
+			sched.lock.Unlock()
+			return "", fmt.Errorf("%s is already being migrated by %s", containerName, other.Id)
+		}
+	}
+	sched.migrations[migration.Id] = migration
+	sched.saveMigration(migration)
+	sched.lock.Unlock()
+
+	log.Infoln("Generating CHECKPOINT_CONTAINER task for migration", migration.Id)
+	tags := map[string]string{
+		shared.Tags.TASK_TYPE:      shared.TaskTypes.CHECKPOINT_CONTAINER,
+		shared.Tags.CONTAINER_NAME: containerName,
+		shared.Tags.FILESERVER_IP:  sched.ExternalServer,
+		shared.Tags.TARGET_HOST:    sourceHost,
+		shared.Tags.MIGRATION_ID:   migration.Id,
+	}
+	sched.pushTask(sched.genTask(tags))
+	return migration.Id, nil
+}
+
+// advanceMigration moves the migration a finished task belongs to on to its
+// next state.
+func (sched *ExampleScheduler) advanceMigration(taskType string, labels *mesos.Labels) {
+	migrationId, err := shared.GetValueFromLabels(labels, shared.Tags.MIGRATION_ID)
+	if err != nil {
+		return
+	}
+	sched.lock.Lock()
+	migration, ok := sched.migrations[migrationId]
+	if !ok || !migration.active() {
+		sched.lock.Unlock()
+		return
+	}
+	now := time.Now()
+	switch taskType {
+	case shared.TaskTypes.CHECKPOINT_CONTAINER:
+		migration.State = MigrationStates.RESTORING
+		migration.Checkpointed = now
+		migration.CheckpointTime = now.Sub(migration.Started)
+	case shared.TaskTypes.RESTORE_CONTAINER:
+		migration.State = MigrationStates.DONE
+		migration.Finished = now
+		migration.RestoreTime = now.Sub(migration.Checkpointed)
+		migration.TotalTime = now.Sub(migration.Started)
+	}
+	sched.saveMigration(migration)
+	next := *migration
+	sched.lock.Unlock()
+
+	switch next.State {
+	case MigrationStates.RESTORING:
+		log.Infof("Migration %s checkpointed %s in %v, restoring on %s", next.Id, next.ContainerName, next.CheckpointTime, next.TargetHost)
+		tags := map[string]string{
+			shared.Tags.TASK_TYPE:      shared.TaskTypes.RESTORE_CONTAINER,
+			shared.Tags.CONTAINER_NAME: next.ContainerName,
+			shared.Tags.FILESERVER_IP:  sched.ExternalServer,
+			shared.Tags.TARGET_HOST:    next.TargetHost,
+			shared.Tags.MIGRATION_ID:   next.Id,
+		}
+		sched.pushTask(sched.genTask(tags))
+	case MigrationStates.DONE:
+		observeMigration(next)
+		log.Infof("Migration %s moved %s from %s to %s in %v", next.Id, next.ContainerName, next.SourceHost, next.TargetHost, next.TotalTime)
+	}
+}
+
+// failMigration marks the migration a task belongs to as failed and notes
+// where that leaves the container.
+func (sched *ExampleScheduler) failMigration(labels *mesos.Labels, reason string) {
+	migrationId, err := shared.GetValueFromLabels(labels, shared.Tags.MIGRATION_ID)
+	if err != nil {
+		return
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	migration, ok := sched.migrations[migrationId]
+	if !ok || !migration.active() {
+		return
+	}
+	switch migration.State {
+	case MigrationStates.CHECKPOINTING:
+		migration.Error = fmt.Sprintf("checkpoint on %s failed: %s; container left on %s", migration.SourceHost, reason, migration.SourceHost)
+	case MigrationStates.RESTORING:
+		migration.Error = fmt.Sprintf("restore on %s failed: %s; container is checkpointed but not running", migration.TargetHost, reason)
+	}
+	migration.State = MigrationStates.FAILED
+	migration.Finished = time.Now()
+	migration.TotalTime = migration.Finished.Sub(migration.Started)
+	sched.saveMigration(migration)
+	observeMigration(*migration)
+	log.Errorf("Migration %s failed: %s", migration.Id, migration.Error)
+}
+
+// GetMigration returns a copy of the migration with the given ID.
+func (sched *ExampleScheduler) GetMigration(migrationId string) (Migration, bool) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	migration, ok := sched.migrations[migrationId]
+	if !ok {
+		return Migration{}, false
+	}
+	return *migration, true
+}
+
+// Migrations returns all migrations, oldest first.
+func (sched *ExampleScheduler) Migrations() []Migration {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	migrations := []Migration{}
+	for _, migration := range sched.migrations {
+		migrations = append(migrations, *migration)
+	}
+	sort.Sort(byStarted(migrations))
+	return migrations
+}
+
+type byStarted []Migration
+
+func (m byStarted) Len() int           { return len(m) }
+func (m byStarted) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byStarted) Less(i, j int) bool { return m[i].Started.Before(m[j].Started) }
+
+// saveMigration persists a migration. The caller must hold sched.lock.
+func (sched *ExampleScheduler) saveMigration(migration *Migration) {
+	if value, err := json.Marshal(migration); err != nil {
+		log.Errorf("Failed to encode migration %s: %v", migration.Id, err)
+	} else {
+		sched.persist(migrationsBucket, migration.Id, value)
+	}
+}
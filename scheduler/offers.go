@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	sched "github.com/mesos/mesos-go/scheduler"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const (
+	defaultRefuseSeconds   = 5
+	defaultSuppressSeconds = 300
+)
+
+// The scheduler driver has no call to suppress offers, so the scheduler
+// suppresses them by declining with a long filter while the task queue is
+// empty, and revives them as soon as new work is queued. Offers that don't
+// fit the queued tasks are declined with a short filter.
+
+// SetOfferFilters sets how many seconds declined offers are refused for
+// while there are queued tasks (refuse) and while there are none (suppress).
+func (sched *ExampleScheduler) SetOfferFilters(refuseSeconds float64, suppressSeconds float64) {
+	sched.offerLock.Lock()
+	defer sched.offerLock.Unlock()
+	sched.refuseSeconds = refuseSeconds
+	sched.suppressSeconds = suppressSeconds
+}
+
+// declineOffer declines an offer none of the queued tasks could use.
+func (sched *ExampleScheduler) declineOffer(driver sched.SchedulerDriver, offer *mesos.Offer) {
+	sched.offerLock.Lock()
+	defer sched.offerLock.Unlock()
+	seconds := sched.refuseSeconds
+	if sched.TaskQueue.Len() == 0 {
+		seconds = sched.suppressSeconds
+		if !sched.suppressed {
+			log.Infoln("Task queue is empty, suppressing offers")
+		}
+		sched.suppressed = true
+	}
+	sched.filteredHosts[offer.GetHostname()] = time.Now().Add(time.Duration(seconds) * time.Second)
+	offersDeclined.Inc()
+	log.Infof("Declining offer %s from %s for %vs", offer.Id.GetValue(), offer.GetHostname(), seconds)
+	sched.Events.Publish(Event{
+		Type:    EventTypes.OFFER_DECLINED,
+		OfferId: offer.Id.GetValue(),
+		Host:    offer.GetHostname(),
+		Message: fmt.Sprintf("refused for %vs", seconds),
+	})
+	if _, err := driver.DeclineOffer(offer.Id, &mesos.Filters{RefuseSeconds: proto.Float64(seconds)}); err != nil {
+		log.Errorf("Failed to decline offer %s: %v", offer.Id.GetValue(), err)
+	}
+}
+
+func (sched *ExampleScheduler) launchFilters() *mesos.Filters {
+	sched.offerLock.Lock()
+	defer sched.offerLock.Unlock()
+	return &mesos.Filters{RefuseSeconds: proto.Float64(sched.refuseSeconds)}
+}
+
+// reviveOffersFor revives offers if task can't be placed because offers are
+// suppressed, or because it is pinned to a host whose offers were declined.
+func (sched *ExampleScheduler) reviveOffersFor(task *mesos.TaskInfo) {
+	sched.offerLock.Lock()
+	defer sched.offerLock.Unlock()
+	if sched.driver == nil {
+		return
+	}
+	revive := sched.suppressed
+	if targetHost, err := shared.GetValueFromLabels(task.Labels, shared.Tags.TARGET_HOST); err == nil {
+		if until, ok := sched.filteredHosts[targetHost]; ok && time.Now().Before(until) {
+			log.Infof("Task %s waits on %s, whose offers are filtered", task.GetName(), targetHost)
+			revive = true
+		}
+	}
+	if !revive {
+		return
+	}
+	log.Infoln("Reviving offers")
+	sched.suppressed = false
+	sched.filteredHosts = make(map[string]time.Time)
+	if _, err := sched.driver.ReviveOffers(); err != nil {
+		log.Errorf("Failed to revive offers: %v", err)
+	}
+}
+
+// setDriver keeps the driver so work queued from outside the driver's
+// callbacks can revive offers.
+func (sched *ExampleScheduler) setDriver(driver sched.SchedulerDriver) {
+	sched.offerLock.Lock()
+	defer sched.offerLock.Unlock()
+	sched.driver = driver
+}
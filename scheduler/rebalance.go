@@ -0,0 +1,242 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// RebalanceConfig controls the rebalancer. It moves containers from the
+// hosts running the most containers to those running the fewest, but only
+// while the two differ by more than Threshold, and never moves a container
+// again within Cooldown of its last move, so that containers don't bounce
+// between hosts. At most Budget migrations are started per Interval. In
+// DryRun mode the moves are only reported.
+type RebalanceConfig struct {
+	Interval  time.Duration
+	Threshold int
+	Budget    int
+	Cooldown  time.Duration
+	DryRun    bool
+}
+
+// RebalanceMove is a migration the rebalancer started, or would have in dry
+// run mode.
+type RebalanceMove struct {
+	ContainerName string
+	SourceHost    string
+	TargetHost    string
+	MigrationId   string `json:",omitempty"`
+	Error         string `json:",omitempty"`
+}
+
+// RebalanceReport is the outcome of one pass of the rebalancer.
+type RebalanceReport struct {
+	Time   time.Time
+	DryRun bool
+	Load   map[string]int //map of hostname to containers running on it, before the moves
+	Moves  []RebalanceMove
+}
+
+// SetRebalancer starts rebalancing every config.Interval, or stops it if the
+// interval is zero.
+func (sched *ExampleScheduler) SetRebalancer(config RebalanceConfig) error {
+	if config.Interval < 0 || config.Threshold < 1 || config.Budget < 1 {
+		return fmt.Errorf("rebalancing needs a threshold and a budget of at least 1")
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	if sched.rebalanceStop != nil {
+		close(sched.rebalanceStop)
+		sched.rebalanceStop = nil
+	}
+	sched.rebalanceConfig = config
+	if config.Interval == 0 {
+		log.Infoln("Rebalancing is off")
+		return nil
+	}
+	log.Infof("Rebalancing every %v: %+v", config.Interval, config)
+	sched.rebalanceStop = make(chan struct{})
+	go sched.runRebalancer(config.Interval, sched.rebalanceStop)
+	return nil
+}
+
+func (sched *ExampleScheduler) runRebalancer(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sched.Rebalance(false)
+		}
+	}
+}
+
+// LastRebalance returns the report of the last pass of the rebalancer.
+func (sched *ExampleScheduler) LastRebalance() (RebalanceReport, bool) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	if sched.lastRebalance == nil {
+		return RebalanceReport{}, false
+	}
+	return *sched.lastRebalance, true
+}
+
+// Rebalance runs one pass of the rebalancer now. dryRun reports the moves
+// without making them, as does a rebalancer configured for dry runs.
+func (sched *ExampleScheduler) Rebalance(dryRun bool) RebalanceReport {
+	sched.lock.Lock()
+	config := sched.rebalanceConfig
+	if config.Threshold < 1 {
+		config.Threshold = 1
+	}
+	if config.Budget < 1 {
+		config.Budget = 1
+	}
+	report := RebalanceReport{Time: time.Now(), DryRun: dryRun || config.DryRun, Load: map[string]int{}}
+	free := map[string]ContainerResources{}
+	for _, host := range sched.hosts {
+		if _, draining := sched.drains[host]; draining {
+			continue
+		}
+		if _, ok := sched.maintenance[host]; ok {
+			continue
+		}
+		report.Load[host] = 0
+		free[host] = sched.hostResources[host]
+	}
+	containers := map[string][]string{}
+	for containerName, host := range sched.ContainerSlaveMap {
+		if _, ok := report.Load[host]; !ok {
+			continue
+		}
+		report.Load[host]++
+		containers[host] = append(containers[host], containerName)
+	}
+	migrating := map[string]bool{}
+	for _, migration := range sched.migrations {
+		if migration.active() {
+			migrating[migration.ContainerName] = true
+		}
+	}
+	lastMoved := map[string]time.Time{}
+	for containerName, moved := range sched.lastMoved {
+		lastMoved[containerName] = moved
+	}
+	sched.lock.Unlock()
+
+	load := map[string]int{}
+	for host, count := range report.Load {
+		load[host] = count
+	}
+	for len(report.Moves) < config.Budget {
+		hosts := []string{}
+		for host := range load {
+			hosts = append(hosts, host)
+		}
+		if len(hosts) < 2 {
+			break
+		}
+		sort.Sort(byLoad{hosts, load})
+		source := hosts[len(hosts)-1]
+		move, ok := sched.pickMove(source, hosts, load, free, containers[source], migrating, lastMoved, config)
+		if !ok {
+			break
+		}
+		move.apply(load, free, containers, sched.resourcesFor(move.ContainerName))
+		migrating[move.ContainerName] = true
+		report.Moves = append(report.Moves, move)
+	}
+
+	for i := range report.Moves {
+		move := &report.Moves[i]
+		if report.DryRun {
+			log.Infof("Rebalance (dry run): would move %s from %s to %s", move.ContainerName, move.SourceHost, move.TargetHost)
+			continue
+		}
+		migrationId, err := sched.MigrateContainerTask(move.ContainerName, move.TargetHost)
+		if err != nil {
+			move.Error = err.Error()
+			log.Errorf("Rebalance: cannot move %s from %s to %s: %v", move.ContainerName, move.SourceHost, move.TargetHost, err)
+			continue
+		}
+		move.MigrationId = migrationId
+		log.Infof("Rebalance: moving %s from %s to %s", move.ContainerName, move.SourceHost, move.TargetHost)
+		sched.lock.Lock()
+		sched.lastMoved[move.ContainerName] = report.Time
+		sched.lock.Unlock()
+	}
+
+	sched.lock.Lock()
+	sched.lastRebalance = &report
+	sched.lock.Unlock()
+	return report
+}
+
+// pickMove finds a container on source to move to the least loaded host it
+// may be placed on and fits on, if that evens out the load.
+func (sched *ExampleScheduler) pickMove(source string, hosts []string, load map[string]int, free map[string]ContainerResources,
+	candidates []string, migrating map[string]bool, lastMoved map[string]time.Time, config RebalanceConfig) (RebalanceMove, bool) {
+	sort.Strings(candidates)
+	for _, target := range hosts {
+		if load[source]-load[target] <= config.Threshold {
+			// hosts are sorted by load, the rest are no better
+			break
+		}
+		for _, containerName := range candidates {
+			if migrating[containerName] || time.Since(lastMoved[containerName]) < config.Cooldown {
+				continue
+			}
+			if sched.hasPendingTask(containerName) {
+				continue
+			}
+			resources := sched.resourcesFor(containerName)
+			if !resources.fits(free[target].Cpus, free[target].Mem, free[target].Disk) {
+				continue
+			}
+			if err := sched.canPlace(containerName, target); err != nil {
+				continue
+			}
+			return RebalanceMove{ContainerName: containerName, SourceHost: source, TargetHost: target}, true
+		}
+	}
+	return RebalanceMove{}, false
+}
+
+// apply updates the load and free resources of the hosts as if the move
+// were done.
+func (m RebalanceMove) apply(load map[string]int, free map[string]ContainerResources, containers map[string][]string, resources ContainerResources) {
+	load[m.SourceHost]--
+	load[m.TargetHost]++
+	target := free[m.TargetHost]
+	target.Cpus -= resources.Cpus
+	target.Mem -= resources.Mem
+	target.Disk -= resources.Disk
+	free[m.TargetHost] = target
+	remaining := []string{}
+	for _, containerName := range containers[m.SourceHost] {
+		if containerName != m.ContainerName {
+			remaining = append(remaining, containerName)
+		}
+	}
+	containers[m.SourceHost] = remaining
+}
+
+// byLoad sorts hosts by the containers running on them, then by name.
+type byLoad struct {
+	hosts []string
+	load  map[string]int
+}
+
+func (b byLoad) Len() int      { return len(b.hosts) }
+func (b byLoad) Swap(i, j int) { b.hosts[i], b.hosts[j] = b.hosts[j], b.hosts[i] }
+func (b byLoad) Less(i, j int) bool {
+	if b.load[b.hosts[i]] != b.load[b.hosts[j]] {
+		return b.load[b.hosts[i]] < b.load[b.hosts[j]]
+	}
+	return b.hosts[i] < b.hosts[j]
+}
@@ -0,0 +1,143 @@
+package scheduler
+
+import (
+	"time"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	sched "github.com/mesos/mesos-go/scheduler"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const (
+	reconcileInitialBackoff = 5 * time.Second
+	reconcileMaxBackoff     = 2 * time.Minute
+	reconcileMaxAttempts    = 6
+)
+
+// reconcileTasks starts a reconciliation round. Explicit reconciliation is
+// repeated with backoff until the master has reported on every in-flight
+// task, then an implicit reconciliation picks up whatever else the master
+// knows about. A newer round (e.g. after another re-registration) supersedes
+// an older one.
+func (sched *ExampleScheduler) reconcileTasks(driver sched.SchedulerDriver) {
+	sched.lock.Lock()
+	sched.reconcileRound++
+	round := sched.reconcileRound
+	sched.reconciling = make(map[string]bool)
+	for taskId := range sched.inFlight {
+		sched.reconciling[taskId] = true
+	}
+	sched.lock.Unlock()
+
+	go sched.runReconciliation(driver, round)
+}
+
+func (sched *ExampleScheduler) runReconciliation(driver sched.SchedulerDriver, round int) {
+	backoff := reconcileInitialBackoff
+	for attempt := 1; attempt <= reconcileMaxAttempts; attempt++ {
+		statuses, current := sched.pendingReconciliation(round)
+		if !current {
+			return
+		}
+		if len(statuses) == 0 {
+			break
+		}
+		log.Infof("Explicitly reconciling %d tasks (attempt %d)", len(statuses), attempt)
+		if _, err := driver.ReconcileTasks(statuses); err != nil {
+			log.Errorf("Explicit reconciliation failed: %v", err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > reconcileMaxBackoff {
+			backoff = reconcileMaxBackoff
+		}
+	}
+	if statuses, current := sched.pendingReconciliation(round); !current {
+		return
+	} else if len(statuses) > 0 {
+		log.Warningf("Master did not report on %d tasks after %d attempts", len(statuses), reconcileMaxAttempts)
+	}
+
+	log.Infoln("Implicitly reconciling tasks")
+	if _, err := driver.ReconcileTasks([]*mesos.TaskStatus{}); err != nil {
+		log.Errorf("Implicit reconciliation failed: %v", err)
+	}
+}
+
+// pendingReconciliation returns the statuses to send for tasks the master has
+// not reported on yet, and whether round is still the current round.
+func (sched *ExampleScheduler) pendingReconciliation(round int) ([]*mesos.TaskStatus, bool) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	if round != sched.reconcileRound {
+		return nil, false
+	}
+	statuses := []*mesos.TaskStatus{}
+	for taskId := range sched.reconciling {
+		task, ok := sched.inFlight[taskId]
+		if !ok {
+			delete(sched.reconciling, taskId)
+			continue
+		}
+		statuses = append(statuses, &mesos.TaskStatus{
+			TaskId:  task.TaskId,
+			SlaveId: task.SlaveId,
+			State:   mesos.TaskState_TASK_STAGING.Enum(),
+		})
+	}
+	return statuses, true
+}
+
+// trackStatus marks a task as reported for the current reconciliation round
+// and recovers the containers of agents the master says are gone. task is the
+// in-flight task the status belongs to, or nil if the scheduler doesn't know
+// it.
+func (sched *ExampleScheduler) trackStatus(status *mesos.TaskStatus, task *mesos.TaskInfo) {
+	taskId := status.TaskId.GetValue()
+	sched.lock.Lock()
+	delete(sched.reconciling, taskId)
+	sched.lock.Unlock()
+
+	if task == nil {
+		if !isTerminalState(status.GetState()) {
+			log.Warningf("Master reports unknown task %s in state %s", taskId, status.GetState().String())
+		}
+		return
+	}
+
+	if !lostWithAgent(status) {
+		return
+	}
+	host, err := shared.GetValueFromLabels(task.Labels, shared.Tags.ACCEPTED_HOST)
+	if err != nil {
+		return
+	}
+	sched.handleHostLost(host)
+	// a container that was being started or restored on the agent isn't
+	// mapped to it yet, so it is recovered along with the others
+	taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+	if taskType == shared.TaskTypes.RUN_CONTAINER || taskType == shared.TaskTypes.RESTORE_CONTAINER {
+		containerName, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME)
+		if _, placed := sched.GetContainerHost(containerName); !placed {
+			sched.recoverContainer(containerName, host)
+		}
+	}
+}
+
+// dropContainersOnHost removes every container mapped to host and returns
+// their names.
+func (sched *ExampleScheduler) dropContainersOnHost(host string) []string {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	dropped := []string{}
+	for containerName, containerHost := range sched.ContainerSlaveMap {
+		if containerHost == host {
+			delete(sched.ContainerSlaveMap, containerName)
+			sched.unpersist(containersBucket, containerName)
+			sched.Events.Publish(Event{Type: EventTypes.CONTAINER_REMOVED, ContainerName: containerName, Host: host, Reason: "host lost"})
+			dropped = append(dropped, containerName)
+		}
+	}
+	return dropped
+}
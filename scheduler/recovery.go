@@ -0,0 +1,183 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+)
+
+const (
+	recoveryBucket    = "recovery"
+	checkpointsBucket = "checkpoints"
+)
+
+// RecoveryPolicies are the ways a container can be brought back after the
+// agent it ran on is lost.
+var RecoveryPolicies = struct {
+	NONE            string
+	LAST_CHECKPOINT string
+	RESTART_FRESH   string
+}{
+	NONE:            "none",
+	LAST_CHECKPOINT: "last-checkpoint",
+	RESTART_FRESH:   "restart-fresh",
+}
+
+func isRecoveryPolicy(policy string) bool {
+	switch policy {
+	case RecoveryPolicies.NONE, RecoveryPolicies.LAST_CHECKPOINT, RecoveryPolicies.RESTART_FRESH:
+		return true
+	}
+	return false
+}
+
+// LostContainer is a container whose agent was lost and that has not been
+// placed again since.
+type LostContainer struct {
+	ContainerName string
+	Host          string
+	LostAt        time.Time
+	Recovery      string
+	RecoveryHost  string
+	Error         string
+}
+
+// SetRecoveryPolicy sets how containerName is recovered if its agent is
+// lost. Containers without a policy use the default set by
+// SetDefaultRecoveryPolicy.
+func (sched *ExampleScheduler) SetRecoveryPolicy(containerName string, policy string) error {
+	if !isRecoveryPolicy(policy) {
+		return fmt.Errorf("unknown recovery policy %q", policy)
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.recoveryPolicies[containerName] = policy
+	sched.persist(recoveryBucket, containerName, []byte(policy))
+	return nil
+}
+
+func (sched *ExampleScheduler) SetDefaultRecoveryPolicy(policy string) error {
+	if !isRecoveryPolicy(policy) {
+		return fmt.Errorf("unknown recovery policy %q", policy)
+	}
+	sched.defaultRecoveryPolicy = policy
+	return nil
+}
+
+func (sched *ExampleScheduler) recoveryPolicy(containerName string) string {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	if policy, ok := sched.recoveryPolicies[containerName]; ok {
+		return policy
+	}
+	return sched.defaultRecoveryPolicy
+}
+
+func (sched *ExampleScheduler) recordCheckpoint(containerName string) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	now := time.Now()
+	sched.lastCheckpoint[containerName] = now
+	sched.persist(checkpointsBucket, containerName, []byte(now.Format(time.RFC3339Nano)))
+}
+
+// LostContainers returns the containers that are waiting for, or could not
+// get, a recovery.
+func (sched *ExampleScheduler) LostContainers() []LostContainer {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	lost := []LostContainer{}
+	for _, container := range sched.lostContainers {
+		lost = append(lost, container)
+	}
+	return lost
+}
+
+// lostWithAgent reports whether status says that the agent of the task is
+// gone. The recovery policies then own the task's container, so the task is
+// not retried.
+func lostWithAgent(status *mesos.TaskStatus) bool {
+	switch status.GetReason() {
+	case mesos.TaskStatus_REASON_SLAVE_REMOVED, mesos.TaskStatus_REASON_SLAVE_UNKNOWN:
+		return true
+	}
+	return false
+}
+
+// handleHostLost marks every container on host as lost and starts the
+// recovery policy of each one.
+func (sched *ExampleScheduler) handleHostLost(host string) {
+	for _, containerName := range sched.dropContainersOnHost(host) {
+		sched.recoverContainer(containerName, host)
+	}
+}
+
+// recoverContainer marks containerName, lost with host, as lost and starts
+// its recovery policy.
+func (sched *ExampleScheduler) recoverContainer(containerName string, host string) {
+	lost := LostContainer{
+		ContainerName: containerName,
+		Host:          host,
+		LostAt:        time.Now(),
+		Recovery:      sched.recoveryPolicy(containerName),
+	}
+	log.Infof("Container %s was lost with agent %s, recovery policy: %s", containerName, host, lost.Recovery)
+
+	switch lost.Recovery {
+	case RecoveryPolicies.LAST_CHECKPOINT:
+		sched.lock.Lock()
+		_, checkpointed := sched.lastCheckpoint[containerName]
+		sched.lock.Unlock()
+		if !checkpointed {
+			lost.Error = "no checkpoint to restore from"
+			break
+		}
+		target, ok := sched.pickRecoveryHost(containerName, host)
+		if !ok {
+			lost.Error = "no healthy host to restore on"
+			break
+		}
+		lost.RecoveryHost = target
+		sched.RestoreContainerTask(containerName, target)
+	case RecoveryPolicies.RESTART_FRESH:
+		sched.RunContainerTask(containerName)
+	}
+	if lost.Error != "" {
+		log.Errorf("Cannot recover %s: %s", containerName, lost.Error)
+	}
+
+	sched.lock.Lock()
+	sched.lostContainers[containerName] = lost
+	sched.lock.Unlock()
+}
+
+// pickRecoveryHost returns the known host, other than exclude, that runs the
+// fewest containers and satisfies the constraints of containerName.
+func (sched *ExampleScheduler) pickRecoveryHost(containerName string, exclude string) (string, bool) {
+	sched.lock.Lock()
+	load := map[string]int{}
+	for _, host := range sched.hosts {
+		if host != exclude {
+			load[host] = 0
+		}
+	}
+	for _, host := range sched.ContainerSlaveMap {
+		if _, ok := load[host]; ok {
+			load[host]++
+		}
+	}
+	sched.lock.Unlock()
+
+	best, found := "", false
+	for host, count := range load {
+		if err := sched.canPlace(containerName, host); err != nil {
+			continue
+		}
+		if !found || count < load[best] || (count == load[best] && host < best) {
+			best, found = host, true
+		}
+	}
+	return best, found
+}
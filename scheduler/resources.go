@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+)
+
+const resourcesBucket = "resources"
+
+// ContainerResources is what every task of a container asks for: the run,
+// and the checkpoints and restores that follow it.
+type ContainerResources struct {
+	Cpus float64
+	Mem  float64
+	Disk float64
+}
+
+func (r ContainerResources) fits(cpus float64, mem float64, disk float64) bool {
+	return r.Cpus <= cpus && r.Mem <= mem && r.Disk <= disk
+}
+
+func (r ContainerResources) String() string {
+	return fmt.Sprintf("cpus=%v mem=%v disk=%v", r.Cpus, r.Mem, r.Disk)
+}
+
+func (r ContainerResources) toMesos() []*mesos.Resource {
+	resources := []*mesos.Resource{
+		util.NewScalarResource("cpus", r.Cpus),
+		util.NewScalarResource("mem", r.Mem),
+	}
+	if r.Disk > 0 {
+		resources = append(resources, util.NewScalarResource("disk", r.Disk))
+	}
+	return resources
+}
+
+func taskResources(task *mesos.TaskInfo) ContainerResources {
+	return ContainerResources{
+		Cpus: getScalar(task.Resources, "cpus"),
+		Mem:  getScalar(task.Resources, "mem"),
+		Disk: getScalar(task.Resources, "disk"),
+	}
+}
+
+// SetContainerResources sets what the tasks of containerName ask for.
+// Containers without resources of their own use the scheduler-wide cpus and
+// mem per task. Zero values are filled in from those defaults as well.
+func (sched *ExampleScheduler) SetContainerResources(containerName string, resources ContainerResources) error {
+	if resources.Cpus < 0 || resources.Mem < 0 || resources.Disk < 0 {
+		return fmt.Errorf("negative resources requested for %s: %v", containerName, resources)
+	}
+	if resources.Cpus == 0 {
+		resources.Cpus = sched.cpuPerTask
+	}
+	if resources.Mem == 0 {
+		resources.Mem = sched.memPerTask
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.containerResources[containerName] = resources
+	if value, err := json.Marshal(resources); err != nil {
+		log.Errorf("Failed to encode resources of %s: %v", containerName, err)
+	} else {
+		sched.persist(resourcesBucket, containerName, value)
+	}
+	return nil
+}
+
+func (sched *ExampleScheduler) resourcesFor(containerName string) ContainerResources {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	if resources, ok := sched.containerResources[containerName]; ok {
+		return resources
+	}
+	return ContainerResources{Cpus: sched.cpuPerTask, Mem: sched.memPerTask}
+}
@@ -0,0 +1,292 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const failuresBucket = "failures"
+
+// RetryPolicy decides whether a failed task is queued again. The n-th retry
+// waits InitialBackoff * 2^(n-1), capped at MaxBackoff. Failures with one of
+// the FatalReasons, and TASK_ERROR, are never retried.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	FatalReasons   []mesos.TaskStatus_Reason
+}
+
+var defaultFatalReasons = []mesos.TaskStatus_Reason{
+	mesos.TaskStatus_REASON_TASK_INVALID,
+	mesos.TaskStatus_REASON_TASK_UNAUTHORIZED,
+	mesos.TaskStatus_REASON_FRAMEWORK_REMOVED,
+	mesos.TaskStatus_REASON_INVALID_FRAMEWORKID,
+}
+
+// RetryPolicies maps a task type to its retry policy. Task types that are
+// not listed here are never retried.
+var RetryPolicies = map[string]RetryPolicy{
+	shared.TaskTypes.RUN_CONTAINER: {
+		MaxAttempts:    3,
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     1 * time.Minute,
+		FatalReasons:   defaultFatalReasons,
+	},
+	shared.TaskTypes.CHECKPOINT_CONTAINER: {
+		MaxAttempts:    3,
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     1 * time.Minute,
+		FatalReasons:   defaultFatalReasons,
+	},
+	shared.TaskTypes.RESTORE_CONTAINER: {
+		MaxAttempts:    5,
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     2 * time.Minute,
+		FatalReasons:   defaultFatalReasons,
+	},
+	shared.TaskTypes.GET_LOGS: {
+		MaxAttempts:    2,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     1 * time.Second,
+		FatalReasons:   defaultFatalReasons,
+	},
+}
+
+// TaskFailure records a task that failed for good.
+type TaskFailure struct {
+	TaskId        string
+	TaskType      string
+	ContainerName string
+	Host          string
+	State         string
+	Reason        string
+	Message       string
+	Attempts      int
+	Time          time.Time
+}
+
+func (p RetryPolicy) shouldRetry(status *mesos.TaskStatus, attempt int) bool {
+	if status.GetState() == mesos.TaskState_TASK_ERROR || attempt >= p.MaxAttempts {
+		return false
+	}
+	for _, reason := range p.FatalReasons {
+		if status.Reason != nil && status.GetReason() == reason {
+			return false
+		}
+	}
+	return true
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+func isFailedState(state mesos.TaskState) bool {
+	switch state {
+	case mesos.TaskState_TASK_FAILED,
+		mesos.TaskState_TASK_LOST,
+		mesos.TaskState_TASK_ERROR:
+		return true
+	}
+	return false
+}
+
+// taskAttempt returns how many times the task has been launched, counting
+// the launch it came from.
+func taskAttempt(labels *mesos.Labels) int {
+	value, err := shared.GetValueFromLabels(labels, shared.Tags.ATTEMPT)
+	if err != nil {
+		return 1
+	}
+	attempt, err := strconv.Atoi(value)
+	if err != nil {
+		return 1
+	}
+	return attempt
+}
+
+// notBefore returns when a queued retry may be launched, or the zero time if
+// the task may be launched right away.
+func notBefore(labels *mesos.Labels) time.Time {
+	value, err := shared.GetValueFromLabels(labels, shared.Tags.NOT_BEFORE)
+	if err != nil {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// armRetry revives offers for a queued retry once its backoff is over, as
+// the offers declined in the meantime may be filtered.
+func (sched *ExampleScheduler) armRetry(task *mesos.TaskInfo) {
+	wait := time.Until(notBefore(task.Labels))
+	if wait <= 0 {
+		return
+	}
+	time.AfterFunc(wait, func() {
+		for _, queued := range sched.TaskQueue.List() {
+			if queued.GetTaskId().GetValue() == task.GetTaskId().GetValue() {
+				sched.reviveOffersFor(task)
+				return
+			}
+		}
+	})
+}
+
+// handleFailure re-enqueues a failed task according to the retry policy of
+// its type, or records the failure once the task runs out of retries.
+func (sched *ExampleScheduler) handleFailure(status *mesos.TaskStatus, labels *mesos.Labels) {
+	taskType, err := shared.GetValueFromLabels(labels, shared.Tags.TASK_TYPE)
+	if err != nil {
+		log.Infof("ERROR: Malformed task info, dropping failed task with status: %v", status)
+		return
+	}
+	containerName, _ := shared.GetValueFromLabels(labels, shared.Tags.CONTAINER_NAME)
+	host, _ := shared.GetValueFromLabels(labels, shared.Tags.ACCEPTED_HOST)
+	attempt := taskAttempt(labels)
+
+	policy, ok := RetryPolicies[taskType]
+	if ok && !lostWithAgent(status) && policy.shouldRetry(status, attempt) {
+		tags := map[string]string{}
+		for _, label := range labels.Labels {
+			tags[label.GetKey()] = label.GetValue()
+		}
+		delete(tags, shared.Tags.ACCEPTED_HOST)
+		delete(tags, shared.Tags.STAGING_DIR)
+		tags[shared.Tags.ATTEMPT] = strconv.Itoa(attempt + 1)
+		backoff := policy.backoff(attempt)
+		log.Infof("%s task for %s failed with %s (%s), retrying in %v (attempt %d of %d)",
+			taskType, containerName, status.GetState().String(), status.GetReason().String(), backoff, attempt+1, policy.MaxAttempts)
+		// The retry is queued right away, so that it is listed, can be
+		// cancelled and survives a restart, but isn't launched before
+		// the backoff is over.
+		tags[shared.Tags.NOT_BEFORE] = time.Now().Add(backoff).Format(time.RFC3339Nano)
+		retry := sched.genTask(tags)
+		sched.pushTask(retry)
+		sched.armRetry(retry)
+		return
+	}
+
+	failure := TaskFailure{
+		TaskId:        status.TaskId.GetValue(),
+		TaskType:      taskType,
+		ContainerName: containerName,
+		Host:          host,
+		State:         status.GetState().String(),
+		Message:       status.GetMessage(),
+		Attempts:      attempt,
+		Time:          time.Now(),
+	}
+	if status.Reason != nil {
+		failure.Reason = status.GetReason().String()
+	}
+	sched.giveUp(failure, labels)
+}
+
+// giveUp records a task that failed for good and fails what it was part of.
+func (sched *ExampleScheduler) giveUp(failure TaskFailure, labels *mesos.Labels) {
+	log.Errorf("%s task for %s failed for good after %d attempts: %s %s %s",
+		failure.TaskType, failure.ContainerName, failure.Attempts, failure.State, failure.Reason, failure.Message)
+	sched.recordFailure(failure)
+	sched.failMigration(labels, failure.State+" "+failure.Reason+" "+failure.Message)
+	if failure.TaskType == shared.TaskTypes.SNAPSHOT_CONTAINER {
+		sched.snapshotDone(failure.ContainerName, failure.State+" "+failure.Reason+" "+failure.Message)
+	}
+}
+
+// strandedReason tells why a queued task can never be launched, or returns
+// "" if it still can. Malformed tasks never match an offer, and a retry
+// pinned to its container's host can't run once the container has left it.
+func (sched *ExampleScheduler) strandedReason(task *mesos.TaskInfo) string {
+	taskType, err := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+	if err != nil {
+		return "malformed task: no task type"
+	}
+	containerName, err := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME)
+	if err != nil {
+		return "malformed task: no container name"
+	}
+	switch taskType {
+	case shared.TaskTypes.GET_LOGS, shared.TaskTypes.CHECKPOINT_CONTAINER, shared.TaskTypes.SNAPSHOT_CONTAINER:
+		targetHost, err := shared.GetValueFromLabels(task.Labels, shared.Tags.TARGET_HOST)
+		if err != nil {
+			return "malformed task: no target host"
+		}
+		if taskAttempt(task.Labels) <= 1 {
+			return ""
+		}
+		if host, ok := sched.GetContainerHost(containerName); !ok || host != targetHost {
+			return containerName + " is no longer on " + targetHost
+		}
+	}
+	return ""
+}
+
+// dropStranded takes the tasks that can never be launched out of the queue
+// and gives up on them, so that they don't wait for a matching offer forever.
+func (sched *ExampleScheduler) dropStranded() {
+	for _, task := range sched.TaskQueue.List() {
+		reason := sched.strandedReason(task)
+		if reason == "" {
+			continue
+		}
+		// the task may have been launched or cancelled in the meantime
+		if sched.TaskQueue.Remove(task.GetTaskId().GetValue()) == nil {
+			continue
+		}
+		sched.saveQueue()
+		sched.Tasks.Cancelled(task.GetTaskId().GetValue())
+		taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+		containerName, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME)
+		sched.giveUp(TaskFailure{
+			TaskId:        task.GetTaskId().GetValue(),
+			TaskType:      taskType,
+			ContainerName: containerName,
+			State:         TaskRecordStates.CANCELLED,
+			Message:       reason,
+			Attempts:      taskAttempt(task.Labels),
+			Time:          time.Now(),
+		}, task.Labels)
+	}
+}
+
+func (sched *ExampleScheduler) recordFailure(failure TaskFailure) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.failures = append(sched.failures, failure)
+	if value, err := json.Marshal(failure); err != nil {
+		log.Errorf("Failed to encode failure of task %s: %v", failure.TaskId, err)
+	} else {
+		sched.persist(failuresBucket, failure.Time.Format(time.RFC3339Nano)+"/"+failure.TaskId, value)
+	}
+}
+
+// Failures returns the recorded failures of a container, or of all
+// containers if containerName is empty.
+func (sched *ExampleScheduler) Failures(containerName string) []TaskFailure {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	failures := []TaskFailure{}
+	for _, failure := range sched.failures {
+		if containerName == "" || failure.ContainerName == containerName {
+			failures = append(failures, failure)
+		}
+	}
+	return failures
+}
@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	sched "github.com/mesos/mesos-go/scheduler"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const (
+	stagingVolumePrefix = "checkpoint-staging-"
+	stagingReserveWait  = 2 * time.Minute
+)
+
+// StagingVolumePath is where the staging volume is mounted, relative to the
+// executor's sandbox.
+const StagingVolumePath = "checkpoint-staging"
+
+// Checkpoint, restore and snapshot tasks stage container images on disk.
+// With staging turned on, the scheduler reserves disk for its role on every
+// agent and creates a persistent volume on it, and those tasks take the
+// volume along, so that images are staged on disk the framework owns. An
+// agent's volume is offered while no task uses it; staging tasks for an
+// agent that has one wait for it.
+
+// SetStaging turns on staging volumes of diskMB megabytes, reserved for role
+// by principal. A diskMB of zero turns them off.
+func (sched *ExampleScheduler) SetStaging(diskMB float64, role string, principal string) {
+	sched.offerLock.Lock()
+	defer sched.offerLock.Unlock()
+	if diskMB > 0 && (role == "" || role == "*" || principal == "") {
+		log.Errorln("Staging volumes need a role other than * and a principal, staging in /tmp instead")
+		diskMB = 0
+	}
+	sched.stagingDisk = diskMB
+	sched.stagingRole = role
+	sched.stagingPrincipal = principal
+}
+
+// usesStaging reports whether task stages a container image.
+func usesStaging(task *mesos.TaskInfo) bool {
+	taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+	switch taskType {
+	case shared.TaskTypes.CHECKPOINT_CONTAINER, shared.TaskTypes.RESTORE_CONTAINER, shared.TaskTypes.SNAPSHOT_CONTAINER:
+		return true
+	}
+	return false
+}
+
+// stagingVolume returns the staging volume in offer, if it is offered.
+func stagingVolume(offer *mesos.Offer) *mesos.Resource {
+	for _, resource := range offer.Resources {
+		if resource.GetName() == "disk" && strings.HasPrefix(resource.GetDisk().GetPersistence().GetId(), stagingVolumePrefix) {
+			return resource
+		}
+	}
+	return nil
+}
+
+// noteStaging remembers which agents have a staging volume. It reports
+// whether offer was used up to create one, in which case it can't be used
+// for tasks.
+func (sched *ExampleScheduler) noteStaging(driver sched.SchedulerDriver, offer *mesos.Offer) bool {
+	sched.offerLock.Lock()
+	defer sched.offerLock.Unlock()
+	slaveId := offer.SlaveId.GetValue()
+	if stagingVolume(offer) != nil {
+		if !sched.stagingVolumes[slaveId] {
+			log.Infof("Staging volume on %s is ready", offer.GetHostname())
+		}
+		sched.stagingVolumes[slaveId] = true
+		delete(sched.stagingReserved, slaveId)
+		return false
+	}
+	if sched.stagingDisk == 0 || sched.stagingVolumes[slaveId] {
+		return false
+	}
+	if since, ok := sched.stagingReserved[slaveId]; ok && time.Since(since) < stagingReserveWait {
+		return false
+	}
+	if getOfferDisk(offer) < sched.stagingDisk {
+		return false
+	}
+
+	persistenceId := stagingVolumePrefix + slaveId
+	reservation := util.NewScalarResourceWithReservation("disk", sched.stagingDisk, sched.stagingPrincipal, sched.stagingRole)
+	volume := util.NewVolumeResourceWithReservation(sched.stagingDisk, StagingVolumePath, persistenceId,
+		mesos.Volume_RW.Enum(), sched.stagingPrincipal, sched.stagingRole)
+	operations := []*mesos.Offer_Operation{
+		util.NewReserveOperation([]*mesos.Resource{reservation}),
+		util.NewCreateOperation([]*mesos.Resource{volume}),
+	}
+	log.Infof("Reserving %vMB of disk on %s for a staging volume", sched.stagingDisk, offer.GetHostname())
+	if _, err := driver.AcceptOffers([]*mesos.OfferID{offer.Id}, operations, &mesos.Filters{}); err != nil {
+		log.Errorf("Failed to reserve a staging volume on %s: %v", offer.GetHostname(), err)
+		return false
+	}
+	sched.stagingReserved[slaveId] = time.Now()
+	sched.Events.Publish(Event{
+		Type:    EventTypes.OFFER_RESERVED,
+		OfferId: offer.Id.GetValue(),
+		Host:    offer.GetHostname(),
+		Message: fmt.Sprintf("%vMB staging volume %s", sched.stagingDisk, persistenceId),
+	})
+	return true
+}
+
+// stagingAgents returns a copy of the agents known to have a staging
+// volume, so that offers can be matched without holding offerLock.
+func (sched *ExampleScheduler) stagingAgents() map[string]bool {
+	sched.offerLock.Lock()
+	defer sched.offerLock.Unlock()
+	agents := make(map[string]bool, len(sched.stagingVolumes))
+	for slaveId, ok := range sched.stagingVolumes {
+		agents[slaveId] = ok
+	}
+	return agents
+}
+
+// stagingFits reports whether task may be launched on c as far as staging
+// is concerned: tasks that stage an image wait for the agent's staging
+// volume, if stagingAgents says it has one.
+func stagingFits(task *mesos.TaskInfo, c *Candidate, stagingAgents map[string]bool) bool {
+	if c.volume != nil || !usesStaging(task) {
+		return true
+	}
+	return !stagingAgents[c.Offer.SlaveId.GetValue()]
+}
+
+// attachStaging hands the staging volume of c to task, if task stages an
+// image and the volume is free.
+func attachStaging(task *mesos.TaskInfo, c *Candidate) {
+	if c.volume == nil || !usesStaging(task) {
+		return
+	}
+	task.Resources = append(task.Resources, c.volume)
+	task.Labels.Labels = append(task.Labels.Labels, shared.CreateLabel(shared.Tags.STAGING_DIR, StagingVolumePath))
+	c.volume = nil
+}
+
+// forgetStaging forgets the staging volume of a lost agent.
+func (sched *ExampleScheduler) forgetStaging(slaveId string) {
+	sched.offerLock.Lock()
+	defer sched.offerLock.Unlock()
+	delete(sched.stagingVolumes, slaveId)
+	delete(sched.stagingReserved, slaveId)
+}
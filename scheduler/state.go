@@ -0,0 +1,413 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	"github.com/emc-cmd/test-framework/shared"
+	"github.com/emc-cmd/test-framework/store"
+)
+
+const (
+	frameworkBucket  = "framework"
+	containersBucket = "containers"
+	queueBucket      = "queue"
+	inFlightBucket   = "inflight"
+
+	frameworkIdKey    = "id"
+	tasksLaunchedKey  = "tasksLaunched"
+	queueSnapshotKey  = "tasks"
+)
+
+// LoadState restores the state of a previous run from s: containers and
+// their settings, queued, in-flight and past tasks, migrations and failures.
+// Every later change is persisted to s. It returns the framework ID of the
+// previous run, or nil if there was none.
+func (sched *ExampleScheduler) LoadState(s *store.Store) (*mesos.FrameworkID, error) {
+	var frameworkId *mesos.FrameworkID
+	value, err := s.Get(frameworkBucket, frameworkIdKey)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		frameworkId = util.NewFrameworkID(string(value))
+	}
+
+	value, err = s.Get(frameworkBucket, tasksLaunchedKey)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		if sched.tasksLaunched, err = strconv.Atoi(string(value)); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.ForEach(containersBucket, func(containerName string, host []byte) error {
+		sched.ContainerSlaveMap[containerName] = string(host)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(inFlightBucket, func(taskId string, value []byte) error {
+		task := &mesos.TaskInfo{}
+		if err := proto.Unmarshal(value, task); err != nil {
+			return err
+		}
+		sched.inFlight[taskId] = task
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(resourcesBucket, func(containerName string, value []byte) error {
+		resources := ContainerResources{}
+		if err := json.Unmarshal(value, &resources); err != nil {
+			return err
+		}
+		sched.containerResources[containerName] = resources
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(placementBucket, func(containerName string, value []byte) error {
+		placement := Placement{}
+		if err := json.Unmarshal(value, &placement); err != nil {
+			return err
+		}
+		placement, err := placement.compile()
+		if err != nil {
+			return err
+		}
+		sched.placements[containerName] = placement
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(schedulesBucket, func(containerName string, value []byte) error {
+		schedule := &CheckpointSchedule{}
+		if err := json.Unmarshal(value, schedule); err != nil {
+			return err
+		}
+		if err := schedule.parse(); err != nil {
+			return err
+		}
+		sched.addSchedule(schedule)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(drainsBucket, func(host string, value []byte) error {
+		drain := &Drain{}
+		if err := json.Unmarshal(value, drain); err != nil {
+			return err
+		}
+		sched.addDrain(drain)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(maintenanceBucket, func(host string, value []byte) error {
+		window := &MaintenanceWindow{}
+		if err := json.Unmarshal(value, window); err != nil {
+			return err
+		}
+		sched.maintenance[window.Host] = window
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(recoveryBucket, func(containerName string, policy []byte) error {
+		sched.recoveryPolicies[containerName] = string(policy)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(checkpointsBucket, func(containerName string, value []byte) error {
+		checkpointed, err := time.Parse(time.RFC3339Nano, string(value))
+		if err != nil {
+			return err
+		}
+		sched.lastCheckpoint[containerName] = checkpointed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(migrationsBucket, func(migrationId string, value []byte) error {
+		migration := &Migration{}
+		if err := json.Unmarshal(value, migration); err != nil {
+			return err
+		}
+		sched.migrations[migrationId] = migration
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ForEach(failuresBucket, func(key string, value []byte) error {
+		failure := TaskFailure{}
+		if err := json.Unmarshal(value, &failure); err != nil {
+			return err
+		}
+		sched.failures = append(sched.failures, failure)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sched.Tasks.load(s); err != nil {
+		return nil, err
+	}
+
+	value, err = s.Get(queueBucket, queueSnapshotKey)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		tasks, err := decodeTasks(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			sched.TaskQueue.Push(task)
+			sched.armRetry(task)
+		}
+	}
+
+	log.Infof("Recovered state: framework %v, %d containers, %d queued tasks, %d in-flight tasks",
+		frameworkId.GetValue(), len(sched.ContainerSlaveMap), sched.TaskQueue.Len(), len(sched.inFlight))
+	sched.store = s
+	sched.resume()
+	return frameworkId, nil
+}
+
+// resume restarts the work recovered by LoadState. It runs once all of the
+// state is loaded and the store is set, so that the work sees the whole
+// state and persists what it changes.
+func (sched *ExampleScheduler) resume() {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	for _, schedule := range sched.schedules {
+		sched.resumeSchedule(schedule)
+	}
+	for _, drain := range sched.drains {
+		sched.resumeDrain(drain)
+	}
+	for _, window := range sched.maintenance {
+		sched.resumeMaintenance(window)
+	}
+}
+
+func (sched *ExampleScheduler) GetContainerHost(containerName string) (string, bool) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	host, ok := sched.ContainerSlaveMap[containerName]
+	return host, ok
+}
+
+func (sched *ExampleScheduler) setContainerHost(containerName string, host string) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.ContainerSlaveMap[containerName] = host
+	delete(sched.lostContainers, containerName)
+	sched.persist(containersBucket, containerName, []byte(host))
+	sched.Events.Publish(Event{Type: EventTypes.CONTAINER_PLACED, ContainerName: containerName, Host: host})
+}
+
+func (sched *ExampleScheduler) deleteContainer(containerName string) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	host, ok := sched.ContainerSlaveMap[containerName]
+	if !ok {
+		return
+	}
+	delete(sched.ContainerSlaveMap, containerName)
+	sched.unpersist(containersBucket, containerName)
+	sched.Events.Publish(Event{Type: EventTypes.CONTAINER_REMOVED, ContainerName: containerName, Host: host})
+}
+
+func (sched *ExampleScheduler) addInFlight(task *mesos.TaskInfo) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.inFlight[task.GetTaskId().GetValue()] = task
+	sched.persist(frameworkBucket, tasksLaunchedKey, []byte(strconv.Itoa(sched.tasksLaunched)))
+	if value, err := proto.Marshal(task); err != nil {
+		log.Errorf("Failed to encode task %s: %v", task.GetTaskId().GetValue(), err)
+	} else {
+		sched.persist(inFlightBucket, task.GetTaskId().GetValue(), value)
+	}
+}
+
+// getInFlight returns the launched task with the given ID, or nil if it is
+// not in flight.
+func (sched *ExampleScheduler) getInFlight(taskId string) *mesos.TaskInfo {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	return sched.inFlight[taskId]
+}
+
+func (sched *ExampleScheduler) removeInFlight(taskId string) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	delete(sched.inFlight, taskId)
+	sched.unpersist(inFlightBucket, taskId)
+}
+
+// noteOffer remembers which host the agent behind offer runs on, and the
+// attributes of that host.
+func (sched *ExampleScheduler) noteOffer(offer *mesos.Offer) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.hosts[offer.SlaveId.GetValue()] = offer.GetHostname()
+	attributes := map[string]string{}
+	for _, attribute := range offer.Attributes {
+		attributes[attribute.GetName()] = attributeValue(attribute)
+	}
+	sched.hostAttributes[offer.GetHostname()] = attributes
+	sched.hostResources[offer.GetHostname()] = ContainerResources{
+		Cpus: getOfferCpu(offer),
+		Mem:  getOfferMem(offer),
+		Disk: getOfferDisk(offer),
+	}
+}
+
+// forgetSlave removes an agent from the known hosts and returns its hostname.
+// Agents that haven't sent an offer since the scheduler started are looked
+// up through the tasks launched on them.
+func (sched *ExampleScheduler) forgetSlave(slaveId string) (string, bool) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	if host, ok := sched.hosts[slaveId]; ok {
+		delete(sched.hosts, slaveId)
+		return host, true
+	}
+	for _, task := range sched.inFlight {
+		if task.SlaveId.GetValue() != slaveId {
+			continue
+		}
+		if host, err := shared.GetValueFromLabels(task.Labels, shared.Tags.ACCEPTED_HOST); err == nil {
+			return host, true
+		}
+	}
+	return "", false
+}
+
+func (sched *ExampleScheduler) saveFrameworkId(frameworkId *mesos.FrameworkID) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.persist(frameworkBucket, frameworkIdKey, []byte(frameworkId.GetValue()))
+}
+
+// saveQueue writes a snapshot of the task queue. Snapshots are serialized so
+// that an older one never overwrites a newer one.
+func (sched *ExampleScheduler) saveQueue() {
+	if sched.store == nil {
+		return
+	}
+	sched.queueLock.Lock()
+	defer sched.queueLock.Unlock()
+	value, err := encodeTasks(sched.TaskQueue.List())
+	if err != nil {
+		log.Errorf("Failed to encode task queue: %v", err)
+		return
+	}
+	if err := sched.store.Put(queueBucket, queueSnapshotKey, value); err != nil {
+		log.Errorf("Failed to persist task queue: %v", err)
+	}
+}
+
+// encodeTasks encodes tasks as a sequence of length-prefixed protobufs, the
+// wire format of a repeated message field. slave_id is required, but queued
+// tasks aren't on an agent yet, so they are encoded with an empty one.
+func encodeTasks(tasks []*mesos.TaskInfo) ([]byte, error) {
+	value := []byte{}
+	for _, task := range tasks {
+		queued := *task
+		if queued.SlaveId == nil {
+			queued.SlaveId = &mesos.SlaveID{Value: proto.String("")}
+		}
+		encoded, err := proto.Marshal(&queued)
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, proto.EncodeVarint(uint64(len(encoded)))...)
+		value = append(value, encoded...)
+	}
+	return value, nil
+}
+
+// decodeTasks decodes tasks encoded by encodeTasks.
+func decodeTasks(value []byte) ([]*mesos.TaskInfo, error) {
+	tasks := []*mesos.TaskInfo{}
+	for len(value) > 0 {
+		length, n := proto.DecodeVarint(value)
+		if n == 0 || uint64(len(value)-n) < length {
+			return nil, fmt.Errorf("truncated task queue snapshot")
+		}
+		task := &mesos.TaskInfo{}
+		if err := proto.Unmarshal(value[n:n+int(length)], task); err != nil {
+			return nil, err
+		}
+		if task.GetSlaveId().GetValue() == "" {
+			task.SlaveId = nil
+		}
+		tasks = append(tasks, task)
+		value = value[n+int(length):]
+	}
+	return tasks, nil
+}
+
+func (sched *ExampleScheduler) persist(bucket string, key string, value []byte) {
+	if sched.store == nil {
+		return
+	}
+	if err := sched.store.Put(bucket, key, value); err != nil {
+		log.Errorf("Failed to persist %s/%s: %v", bucket, key, err)
+	}
+}
+
+func (sched *ExampleScheduler) unpersist(bucket string, key string) {
+	if sched.store == nil {
+		return
+	}
+	if err := sched.store.Delete(bucket, key); err != nil {
+		log.Errorf("Failed to delete %s/%s: %v", bucket, key, err)
+	}
+}
+
+func isTerminalState(state mesos.TaskState) bool {
+	switch state {
+	case mesos.TaskState_TASK_FINISHED,
+		mesos.TaskState_TASK_FAILED,
+		mesos.TaskState_TASK_KILLED,
+		mesos.TaskState_TASK_LOST,
+		mesos.TaskState_TASK_ERROR:
+		return true
+	}
+	return false
+}
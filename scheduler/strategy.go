@@ -0,0 +1,165 @@
+package scheduler
+
+import (
+	"fmt"
+	"math/rand"
+
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+// Candidate is an offer a task fits in, with what is left of it after the
+// tasks already placed on it in the same round of offers.
+type Candidate struct {
+	Offer      *mesos.Offer
+	Cpus       float64
+	Mem        float64
+	Disk       float64
+	Containers int //containers on the offer's host, including those placed this round
+
+	tasks  []*mesos.TaskInfo
+	volume *mesos.Resource //staging volume in the offer, until a task takes it
+}
+
+// PlacementStrategy picks which offer a task is launched on, among those it
+// fits in and is allowed on.
+type PlacementStrategy interface {
+	// Pick returns the index of the candidate to place task on. candidates
+	// is never empty.
+	Pick(task *mesos.TaskInfo, candidates []*Candidate) int
+}
+
+var PlacementStrategyNames = struct {
+	FIRST_FIT string
+	BIN_PACK  string
+	SPREAD    string
+	RANDOM    string
+}{
+	FIRST_FIT: "first-fit",
+	BIN_PACK:  "bin-pack",
+	SPREAD:    "spread",
+	RANDOM:    "random",
+}
+
+// PlacementStrategies are the built-in strategies, by name.
+var PlacementStrategies = map[string]PlacementStrategy{
+	PlacementStrategyNames.FIRST_FIT: FirstFit{},
+	PlacementStrategyNames.BIN_PACK:  BinPack{},
+	PlacementStrategyNames.SPREAD:    Spread{},
+	PlacementStrategyNames.RANDOM:    Random{},
+}
+
+// FirstFit places a task on the first offer it fits in.
+type FirstFit struct{}
+
+func (FirstFit) Pick(task *mesos.TaskInfo, candidates []*Candidate) int {
+	return 0
+}
+
+// BinPack places a task on the offer it leaves the least cpus, then the
+// least memory, of, so that other agents stay free for large containers.
+type BinPack struct{}
+
+func (BinPack) Pick(task *mesos.TaskInfo, candidates []*Candidate) int {
+	best := 0
+	for i, c := range candidates {
+		b := candidates[best]
+		if c.Cpus < b.Cpus || (c.Cpus == b.Cpus && c.Mem < b.Mem) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Spread places a task on the host running the fewest containers, then on
+// the offer with the most cpus left, so that losing an agent hurts least.
+type Spread struct{}
+
+func (Spread) Pick(task *mesos.TaskInfo, candidates []*Candidate) int {
+	best := 0
+	for i, c := range candidates {
+		b := candidates[best]
+		if c.Containers < b.Containers || (c.Containers == b.Containers && c.Cpus > b.Cpus) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Random places a task on any offer it fits in.
+type Random struct{}
+
+func (Random) Pick(task *mesos.TaskInfo, candidates []*Candidate) int {
+	return rand.Intn(len(candidates))
+}
+
+// SetDefaultPlacementStrategy sets the strategy for containers that don't
+// ask for their own.
+func (sched *ExampleScheduler) SetDefaultPlacementStrategy(name string) error {
+	if _, ok := PlacementStrategies[name]; !ok {
+		return fmt.Errorf("unknown placement strategy %q", name)
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.defaultStrategy = name
+	return nil
+}
+
+// strategyFor returns the strategy that places task: the one the task was
+// queued with, else its container's, else the default.
+func (sched *ExampleScheduler) strategyFor(task *mesos.TaskInfo) PlacementStrategy {
+	if name, err := shared.GetValueFromLabels(task.Labels, shared.Tags.STRATEGY); err == nil {
+		if strategy, ok := PlacementStrategies[name]; ok {
+			return strategy
+		}
+	}
+	containerName, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME)
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	name := sched.placements[containerName].Strategy
+	if name == "" {
+		name = sched.defaultStrategy
+	}
+	return PlacementStrategies[name]
+}
+
+// newCandidates returns a candidate for each offer, counting the containers
+// already on its host.
+func (sched *ExampleScheduler) newCandidates(offers []*mesos.Offer) []*Candidate {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	load := map[string]int{}
+	for _, host := range sched.ContainerSlaveMap {
+		load[host]++
+	}
+	candidates := []*Candidate{}
+	for _, offer := range offers {
+		candidates = append(candidates, &Candidate{
+			Offer:      offer,
+			Cpus:       getOfferCpu(offer),
+			Mem:        getOfferMem(offer),
+			Disk:       getOfferDisk(offer),
+			Containers: load[offer.GetHostname()],
+			volume:     stagingVolume(offer),
+		})
+	}
+	return candidates
+}
+
+// place records that task was placed on c.
+func place(task *mesos.TaskInfo, c *Candidate, candidates []*Candidate) {
+	c.tasks = append(c.tasks, task)
+	resources := taskResources(task)
+	c.Cpus -= resources.Cpus
+	c.Mem -= resources.Mem
+	c.Disk -= resources.Disk
+	taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+	if taskType != shared.TaskTypes.RUN_CONTAINER && taskType != shared.TaskTypes.RESTORE_CONTAINER {
+		return
+	}
+	for _, other := range candidates {
+		if other.Offer.GetHostname() == c.Offer.GetHostname() {
+			other.Containers++
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const (
+	PRIORITY_HIGH = iota
+	PRIORITY_NORMAL
+	PRIORITY_LOW
+	numPriorities
+)
+
+// TaskPriorities maps a task type to its priority class. Task types that are
+// not listed here are queued with PRIORITY_NORMAL.
+var TaskPriorities = map[string]int{
+	shared.TaskTypes.CHECKPOINT_CONTAINER: PRIORITY_HIGH,
+	shared.TaskTypes.RESTORE_CONTAINER:    PRIORITY_HIGH,
+	shared.TaskTypes.GET_LOGS:             PRIORITY_NORMAL,
+	shared.TaskTypes.SNAPSHOT_CONTAINER:   PRIORITY_NORMAL,
+	shared.TaskTypes.RUN_CONTAINER:        PRIORITY_NORMAL,
+	shared.TaskTypes.TEST_TASK:            PRIORITY_LOW,
+}
+
+// TaskQueue holds tasks waiting for an offer. It is safe for concurrent use:
+// the trigger server pushes to it while the driver pops from it in
+// ResourceOffers. Tasks are handed out highest priority class first and in
+// FIFO order within a class.
+type TaskQueue struct {
+	lock    sync.Mutex
+	classes [numPriorities][]*mesos.TaskInfo
+}
+
+func NewTaskQueue() *TaskQueue {
+	return &TaskQueue{}
+}
+
+func taskPriority(task *mesos.TaskInfo) int {
+	taskType, err := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+	if err != nil {
+		return PRIORITY_NORMAL
+	}
+	if priority, ok := TaskPriorities[taskType]; ok {
+		return priority
+	}
+	return PRIORITY_NORMAL
+}
+
+// Push appends a task to the back of its priority class.
+func (q *TaskQueue) Push(task *mesos.TaskInfo) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	priority := taskPriority(task)
+	q.classes[priority] = append(q.classes[priority], task)
+}
+
+// Pop removes and returns the next task, or nil if the queue is empty.
+func (q *TaskQueue) Pop() *mesos.TaskInfo {
+	return q.PopMatching(func(*mesos.TaskInfo) bool { return true })
+}
+
+// PopMatching removes and returns the first task, in dispatch order, for
+// which match returns true. Tasks that don't match keep their position.
+// It returns nil if no task matches.
+func (q *TaskQueue) PopMatching(match func(*mesos.TaskInfo) bool) *mesos.TaskInfo {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for priority, tasks := range q.classes {
+		for i, task := range tasks {
+			if match(task) {
+				q.classes[priority] = append(tasks[:i:i], tasks[i+1:]...)
+				return task
+			}
+		}
+	}
+	return nil
+}
+
+// Remove drops the queued task with the given ID. It returns the removed
+// task, or nil if no such task is queued.
+func (q *TaskQueue) Remove(taskId string) *mesos.TaskInfo {
+	return q.PopMatching(func(task *mesos.TaskInfo) bool {
+		return task.GetTaskId().GetValue() == taskId
+	})
+}
+
+// Len returns the number of queued tasks.
+func (q *TaskQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	count := 0
+	for _, tasks := range q.classes {
+		count += len(tasks)
+	}
+	return count
+}
+
+// List returns a snapshot of the queued tasks in dispatch order.
+func (q *TaskQueue) List() []*mesos.TaskInfo {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	list := []*mesos.TaskInfo{}
+	for _, tasks := range q.classes {
+		list = append(list, tasks...)
+	}
+	return list
+}
+
+func (q *TaskQueue) String() string {
+	lines := []string{}
+	for _, task := range q.List() {
+		taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+		containerName, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME)
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%s", task.GetTaskId().GetValue(), taskType, containerName))
+	}
+	return fmt.Sprintf("[%d tasks]\n%s", len(lines), strings.Join(lines, "\n"))
+}
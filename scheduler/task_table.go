@@ -0,0 +1,229 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/emc-cmd/test-framework/shared"
+	"github.com/emc-cmd/test-framework/store"
+)
+
+const tasksBucket = "tasks"
+
+// TaskRecordStates are the states a task goes through before Mesos reports
+// on it. After launch, a task takes the names of the Mesos task states.
+var TaskRecordStates = struct {
+	QUEUED    string
+	LAUNCHED  string
+	CANCELLED string
+}{
+	QUEUED:    "QUEUED",
+	LAUNCHED:  "LAUNCHED",
+	CANCELLED: "CANCELLED",
+}
+
+type TaskTransition struct {
+	State   string
+	Time    time.Time
+	Reason  string `json:",omitempty"`
+	Message string `json:",omitempty"`
+}
+
+// TaskRecord is what the scheduler knows about a task, from the moment it is
+// queued until it reaches a terminal state.
+type TaskRecord struct {
+	TaskId        string
+	TaskType      string
+	ContainerName string
+	MigrationId   string `json:",omitempty"`
+	Host          string
+	Attempt       int
+	State         string
+	Created       time.Time
+	Updated       time.Time
+	Transitions   []TaskTransition
+	Progress      []shared.Progress `json:",omitempty"` //phases reported by the executor
+}
+
+// TaskTable records every task the scheduler generates. It is safe for
+// concurrent use, publishes every transition to events and persists records
+// to a store once one is attached.
+type TaskTable struct {
+	lock    sync.Mutex
+	records map[string]*TaskRecord
+	store   *store.Store
+	events  *EventBus
+}
+
+func NewTaskTable(events *EventBus) *TaskTable {
+	return &TaskTable{
+		records: make(map[string]*TaskRecord),
+		events:  events,
+	}
+}
+
+// load reads the records in s and persists every later change to it.
+func (t *TaskTable) load(s *store.Store) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	err := s.ForEach(tasksBucket, func(taskId string, value []byte) error {
+		record := &TaskRecord{}
+		if err := json.Unmarshal(value, record); err != nil {
+			return err
+		}
+		t.records[taskId] = record
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.store = s
+	return nil
+}
+
+// Add records a newly queued task.
+func (t *TaskTable) Add(task *mesos.TaskInfo) {
+	taskType, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.TASK_TYPE)
+	containerName, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.CONTAINER_NAME)
+	migrationId, _ := shared.GetValueFromLabels(task.Labels, shared.Tags.MIGRATION_ID)
+	now := time.Now()
+	record := &TaskRecord{
+		TaskId:        task.GetTaskId().GetValue(),
+		TaskType:      taskType,
+		ContainerName: containerName,
+		MigrationId:   migrationId,
+		Attempt:       taskAttempt(task.Labels),
+		State:         TaskRecordStates.QUEUED,
+		Created:       now,
+		Updated:       now,
+		Transitions:   []TaskTransition{{State: TaskRecordStates.QUEUED, Time: now}},
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.records[record.TaskId] = record
+	t.save(record)
+	t.events.Publish(taskEvent(record, record.Transitions[0]))
+}
+
+// Launched records that a task was launched on host.
+func (t *TaskTable) Launched(taskId string, host string) {
+	t.transition(taskId, TaskTransition{State: TaskRecordStates.LAUNCHED, Time: time.Now()}, host)
+}
+
+// Cancelled records that a task was taken out of the queue.
+func (t *TaskTable) Cancelled(taskId string) {
+	t.transition(taskId, TaskTransition{State: TaskRecordStates.CANCELLED, Time: time.Now()}, "")
+}
+
+// Update records a status update from Mesos.
+func (t *TaskTable) Update(status *mesos.TaskStatus) {
+	transition := TaskTransition{
+		State:   status.GetState().String(),
+		Time:    time.Now(),
+		Message: status.GetMessage(),
+	}
+	if status.Reason != nil {
+		transition.Reason = status.GetReason().String()
+	}
+	t.transition(status.TaskId.GetValue(), transition, "")
+}
+
+func (t *TaskTable) transition(taskId string, transition TaskTransition, host string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	record, ok := t.records[taskId]
+	if !ok {
+		return
+	}
+	last := record.Transitions[len(record.Transitions)-1]
+	if last.State == transition.State && last.Reason == transition.Reason {
+		// repeated status, e.g. from reconciliation
+		return
+	}
+	record.State = transition.State
+	record.Updated = transition.Time
+	record.Transitions = append(record.Transitions, transition)
+	if host != "" {
+		record.Host = host
+	}
+	t.save(record)
+	t.events.Publish(taskEvent(record, transition))
+}
+
+// Progress records a phase the executor reported for a task. It returns
+// false if the task is unknown.
+func (t *TaskTable) Progress(progress shared.Progress) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	record, ok := t.records[progress.TaskId]
+	if !ok {
+		return false
+	}
+	record.Progress = append(record.Progress, progress)
+	t.save(record)
+	event := Event{
+		Type:          EventTypes.TASK_PROGRESS,
+		TaskId:        record.TaskId,
+		TaskType:      record.TaskType,
+		ContainerName: record.ContainerName,
+		Host:          record.Host,
+		Phase:         progress.Phase,
+		Done:          progress.Done,
+		Bytes:         progress.Bytes,
+		Duration:      progress.Duration,
+		LogTime:       progress.LogTime,
+	}
+	t.events.Publish(event)
+	return true
+}
+
+// Get returns a copy of the record of a task.
+func (t *TaskTable) Get(taskId string) (TaskRecord, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	record, ok := t.records[taskId]
+	if !ok {
+		return TaskRecord{}, false
+	}
+	return *record, true
+}
+
+// List returns the records of a container's tasks, or of all tasks if
+// containerName is empty, oldest first.
+func (t *TaskTable) List(containerName string) []TaskRecord {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	records := []TaskRecord{}
+	for _, record := range t.records {
+		if containerName == "" || record.ContainerName == containerName {
+			records = append(records, *record)
+		}
+	}
+	sort.Sort(byCreated(records))
+	return records
+}
+
+type byCreated []TaskRecord
+
+func (r byCreated) Len() int           { return len(r) }
+func (r byCreated) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byCreated) Less(i, j int) bool { return r[i].Created.Before(r[j].Created) }
+
+// save persists a record. The caller must hold t.lock.
+func (t *TaskTable) save(record *TaskRecord) {
+	if t.store == nil {
+		return
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Failed to encode record of task %s: %v", record.TaskId, err)
+		return
+	}
+	if err := t.store.Put(tasksBucket, record.TaskId, value); err != nil {
+		log.Errorf("Failed to persist record of task %s: %v", record.TaskId, err)
+	}
+}
@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"time"
+
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+// MigrationTiming breaks down how long it took to move a container: a
+// checkpoint followed by a restore, whether or not they were part of a
+// Migration. The durations are the phases the executors reported. Downtime
+// runs from the container's last log line before it was frozen to its first
+// after it was restored, and is zero if either is unknown.
+type MigrationTiming struct {
+	ContainerName    string
+	MigrationId      string `json:",omitempty"`
+	CheckpointTaskId string `json:",omitempty"`
+	RestoreTaskId    string `json:",omitempty"`
+	SourceHost       string `json:",omitempty"`
+	TargetHost       string `json:",omitempty"`
+	Freeze           time.Duration
+	Archive          time.Duration
+	Upload           time.Duration
+	Download         time.Duration
+	Restore          time.Duration
+	LastLog          time.Time `json:",omitempty"`
+	FirstLog         time.Time `json:",omitempty"`
+	Downtime         time.Duration
+	Complete         bool //whether the restore has finished
+}
+
+// Timings returns the timings of a container's checkpoints and restores, or
+// of all containers if containerName is empty, oldest first. They are worked
+// out from the task records, so only finished tasks count. A checkpoint that
+// hasn't been restored yet gives an incomplete timing.
+func (sched *ExampleScheduler) Timings(containerName string) []MigrationTiming {
+	finished := mesos.TaskState_TASK_FINISHED.String()
+	timings := []*MigrationTiming{}
+	open := map[string]*MigrationTiming{} //map of Container name to its last checkpoint's timing
+	for _, record := range sched.Tasks.List(containerName) {
+		if record.State != finished {
+			continue
+		}
+		switch record.TaskType {
+		case shared.TaskTypes.CHECKPOINT_CONTAINER:
+			timing := &MigrationTiming{
+				ContainerName:    record.ContainerName,
+				MigrationId:      record.MigrationId,
+				CheckpointTaskId: record.TaskId,
+				SourceHost:       record.Host,
+			}
+			timing.add(record.Progress)
+			timings = append(timings, timing)
+			open[record.ContainerName] = timing
+		case shared.TaskTypes.RESTORE_CONTAINER:
+			timing, ok := open[record.ContainerName]
+			if !ok {
+				// restored from an image checkpointed before the records were kept
+				timing = &MigrationTiming{ContainerName: record.ContainerName, MigrationId: record.MigrationId}
+				timings = append(timings, timing)
+			}
+			delete(open, record.ContainerName)
+			timing.RestoreTaskId = record.TaskId
+			timing.TargetHost = record.Host
+			timing.Complete = true
+			timing.add(record.Progress)
+		}
+	}
+
+	result := make([]MigrationTiming, len(timings))
+	for i, timing := range timings {
+		if !timing.LastLog.IsZero() && !timing.FirstLog.IsZero() {
+			timing.Downtime = timing.FirstLog.Sub(timing.LastLog)
+		}
+		result[i] = *timing
+	}
+	return result
+}
+
+// add fills in the phases reported for one of the timing's tasks.
+func (t *MigrationTiming) add(progress []shared.Progress) {
+	for _, p := range progress {
+		if !p.Done {
+			continue
+		}
+		switch p.Phase {
+		case shared.ProgressPhases.CHECKPOINTING:
+			t.Freeze = p.Duration
+		case shared.ProgressPhases.ARCHIVING:
+			t.Archive = p.Duration
+		case shared.ProgressPhases.UPLOADING:
+			t.Upload = p.Duration
+		case shared.ProgressPhases.DOWNLOADING:
+			t.Download = p.Duration
+		case shared.ProgressPhases.RESTORING:
+			t.Restore = p.Duration
+		case shared.ProgressPhases.LAST_LOG:
+			t.LastLog = p.LogTime
+		case shared.ProgressPhases.FIRST_LOG:
+			t.FirstLog = p.LogTime
+		}
+	}
+}
+
+// TimingsCSV renders timings as CSV, with durations in seconds.
+func TimingsCSV(timings []MigrationTiming) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"container", "migration_id", "checkpoint_task_id", "restore_task_id", "source_host", "target_host",
+		"freeze_s", "archive_s", "upload_s", "download_s", "restore_s", "last_log", "first_log", "downtime_s", "complete"})
+	for _, t := range timings {
+		w.Write([]string{t.ContainerName, t.MigrationId, t.CheckpointTaskId, t.RestoreTaskId, t.SourceHost, t.TargetHost,
+			csvSeconds(t.Freeze), csvSeconds(t.Archive), csvSeconds(t.Upload), csvSeconds(t.Download), csvSeconds(t.Restore),
+			csvTime(t.LastLog), csvTime(t.FirstLog), csvSeconds(t.Downtime), fmt.Sprint(t.Complete)})
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+func csvSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
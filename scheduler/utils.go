@@ -6,8 +6,17 @@ import (
 	util "github.com/mesos/mesos-go/mesosutil"
 )
 
+// getOfferScalar returns the unreserved amount of name in offer. Tasks ask
+// for resources of the default role, so those reserved for the framework's
+// role can't be launched on.
 func getOfferScalar(offer *mesos.Offer, name string) float64 {
-	resources := util.FilterResources(offer.Resources, func(res *mesos.Resource) bool {
+	return getScalar(util.FilterResources(offer.Resources, func(res *mesos.Resource) bool {
+		return (res.GetRole() == "" || res.GetRole() == "*") && res.Reservation == nil
+	}), name)
+}
+
+func getScalar(resources []*mesos.Resource, name string) float64 {
+	resources = util.FilterResources(resources, func(res *mesos.Resource) bool {
 		return res.GetName() == name
 	})
 
@@ -27,8 +36,20 @@ func getOfferMem(offer *mesos.Offer) float64 {
 	return getOfferScalar(offer, "mem")
 }
 
+// getOfferDisk returns the disk in offer that isn't reserved or taken by a
+// persistent volume.
+func getOfferDisk(offer *mesos.Offer) float64 {
+	disk := 0.0
+	for _, res := range offer.Resources {
+		if res.GetName() == "disk" && res.Reservation == nil && res.Disk == nil {
+			disk += res.GetScalar().GetValue()
+		}
+	}
+	return disk
+}
+
 func logOffers(offers []*mesos.Offer) {
 	for _, offer := range offers {
-		log.Infof("Received Offer <%v> with cpus=%v mem=%v", offer.Id.GetValue(), getOfferCpu(offer), getOfferMem(offer))
+		log.Infof("Received Offer <%v> with cpus=%v mem=%v disk=%v", offer.Id.GetValue(), getOfferCpu(offer), getOfferMem(offer), getOfferDisk(offer))
 	}
 }
@@ -6,12 +6,24 @@ var Tags = struct {
 	FILESERVER_IP string
 	TARGET_HOST string
 	ACCEPTED_HOST string
+	ATTEMPT string
+	MIGRATION_ID string
+	RETENTION string
+	STAGING_DIR string
+	NOT_BEFORE string
+	STRATEGY string
 }{
 	TASK_TYPE: "TASK_TYPE",
 	CONTAINER_NAME: "CONTAINER_NAME",
 	FILESERVER_IP: "FILESERVER_IP",
 	TARGET_HOST: "TARGET_HOST",
 	ACCEPTED_HOST: "ACCEPTED_HOST",
+	ATTEMPT: "ATTEMPT",
+	MIGRATION_ID: "MIGRATION_ID",
+	RETENTION: "RETENTION",
+	STAGING_DIR: "STAGING_DIR",
+	NOT_BEFORE: "NOT_BEFORE",
+	STRATEGY: "STRATEGY",
 }
 
 var TaskTypes = struct {
@@ -20,10 +32,12 @@ var TaskTypes = struct {
 	RESTORE_CONTAINER string
 	TEST_TASK string
 	GET_LOGS string
+	SNAPSHOT_CONTAINER string
 }{
 	RUN_CONTAINER: "RUN_CONTAINER",
 	CHECKPOINT_CONTAINER: "CHECKPOINT_CONTAINER",
 	RESTORE_CONTAINER: "RESTORE_CONTAINER",
 	TEST_TASK: "TEST_TASK",
 	GET_LOGS: "GET_LOGS",
+	SNAPSHOT_CONTAINER: "SNAPSHOT_CONTAINER",
 }
\ No newline at end of file
@@ -0,0 +1,63 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// progressPrefix marks framework messages that carry a Progress.
+const progressPrefix = "progress "
+
+var ProgressPhases = struct {
+	CHECKPOINTING string
+	ARCHIVING     string
+	UPLOADING     string
+	DOWNLOADING   string
+	RESTORING     string
+	LAST_LOG      string
+	FIRST_LOG     string
+}{
+	CHECKPOINTING: "CHECKPOINTING",
+	ARCHIVING:     "ARCHIVING",
+	UPLOADING:     "UPLOADING",
+	DOWNLOADING:   "DOWNLOADING",
+	RESTORING:     "RESTORING",
+	LAST_LOG:      "LAST_LOG",
+	FIRST_LOG:     "FIRST_LOG",
+}
+
+// Progress is what the executor reports to the scheduler when a task enters
+// or finishes a phase. Bytes and Duration are set once the phase is Done.
+// LAST_LOG and FIRST_LOG aren't phases but carry the LogTime of the
+// container's last log line before it was frozen and its first after it was
+// restored, which bound the container's downtime.
+type Progress struct {
+	TaskId   string
+	Phase    string
+	Done     bool
+	Bytes    int64         `json:",omitempty"`
+	Duration time.Duration `json:",omitempty"`
+	LogTime  time.Time     `json:",omitempty"`
+	Time     time.Time
+}
+
+// EncodeProgress turns p into a framework message.
+func EncodeProgress(p Progress) (string, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return progressPrefix + string(body), nil
+}
+
+// DecodeProgress reads a framework message written by EncodeProgress.
+func DecodeProgress(msg string) (Progress, error) {
+	var p Progress
+	if !strings.HasPrefix(msg, progressPrefix) {
+		return p, errors.New("not a progress message")
+	}
+	err := json.Unmarshal([]byte(strings.TrimPrefix(msg, progressPrefix)), &p)
+	return p, err
+}
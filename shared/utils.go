@@ -7,7 +7,7 @@ import (
 
 
 func GetValueFromLabels(labels *mesos.Labels, key string) (string, error) {
-	for _, label := range labels.Labels {
+	for _, label := range labels.GetLabels() {
 		if *label.Key == key {
 			return *label.Value, nil
 		}
@@ -0,0 +1,89 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Config describes the simulated cluster.
+type Config struct {
+	Hosts int
+	Cpus  float64
+	Mem   float64
+	Disk  float64
+	// Attributes maps an attribute name to its values, which are handed out
+	// to the hosts in turn.
+	Attributes    map[string][]string
+	OfferInterval time.Duration
+	// Latency is how long a simulated task runs, unless Latencies has an
+	// entry for its task type. Every run varies by up to Jitter times the
+	// latency either way.
+	Latency     time.Duration
+	Latencies   map[string]time.Duration
+	Jitter      float64
+	FailureRate float64 //chance of a task failing, from 0 to 1
+	Seed        int64
+}
+
+func (c Config) validate() error {
+	if c.Hosts < 1 {
+		return fmt.Errorf("a cluster needs at least one host")
+	}
+	if c.Cpus <= 0 || c.Mem <= 0 {
+		return fmt.Errorf("hosts need cpus and mem")
+	}
+	if c.OfferInterval <= 0 {
+		return fmt.Errorf("the offer interval must be positive")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		return fmt.Errorf("the failure rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// ParseAttributes reads attributes written as name=value|value,name=value,
+// e.g. rack=r1|r2,zone=z1.
+func ParseAttributes(s string) (map[string][]string, error) {
+	attributes := map[string][]string{}
+	for name, values := range splitPairs(s) {
+		if values == "" {
+			return nil, fmt.Errorf("attribute %q has no values", name)
+		}
+		attributes[name] = strings.Split(values, "|")
+	}
+	return attributes, nil
+}
+
+// ParseLatencies reads latencies by task type written as
+// TASK_TYPE=duration,TASK_TYPE=duration, e.g. CHECKPOINT_CONTAINER=10s.
+func ParseLatencies(s string) (map[string]time.Duration, error) {
+	latencies := map[string]time.Duration{}
+	for taskType, value := range splitPairs(s) {
+		latency, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("latency of %s: %v", taskType, err)
+		}
+		latencies[taskType] = latency
+	}
+	return latencies, nil
+}
+
+func splitPairs(s string) map[string]string {
+	pairs := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		pairs[parts[0]] = value
+	}
+	return pairs
+}
@@ -0,0 +1,211 @@
+package simulator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+const (
+	stagingDelay   = 50 * time.Millisecond
+	simulatedImage = 64 << 20 //bytes of a simulated checkpoint image
+	logInterval    = time.Second
+)
+
+// step is a phase of a simulated task, taking share of its latency.
+type step struct {
+	phase string
+	share float64
+}
+
+// steps are the phases the executor reports for checkpoints and restores.
+// Other task types just take their latency.
+var steps = map[string][]step{
+	shared.TaskTypes.CHECKPOINT_CONTAINER: {
+		{shared.ProgressPhases.CHECKPOINTING, 0.3},
+		{shared.ProgressPhases.ARCHIVING, 0.2},
+		{shared.ProgressPhases.UPLOADING, 0.5},
+	},
+	shared.TaskTypes.SNAPSHOT_CONTAINER: {
+		{shared.ProgressPhases.CHECKPOINTING, 0.3},
+		{shared.ProgressPhases.ARCHIVING, 0.2},
+		{shared.ProgressPhases.UPLOADING, 0.5},
+	},
+	shared.TaskTypes.RESTORE_CONTAINER: {
+		{shared.ProgressPhases.DOWNLOADING, 0.5},
+		{shared.ProgressPhases.RESTORING, 0.5},
+	},
+}
+
+// task is a task running on a simulated executor.
+type task struct {
+	master        *Master
+	agent         *agent
+	info          *mesos.TaskInfo
+	taskType      string
+	containerName string
+	cpus          float64
+	mem           float64
+	disk          float64
+	state         mesos.TaskState //guarded by master.lock
+	killed        chan struct{}
+	aborted       chan struct{}
+	killOnce      sync.Once
+	stopOnce      sync.Once
+}
+
+func newTask(m *Master, a *agent, info *mesos.TaskInfo) *task {
+	taskType, _ := shared.GetValueFromLabels(info.Labels, shared.Tags.TASK_TYPE)
+	containerName, _ := shared.GetValueFromLabels(info.Labels, shared.Tags.CONTAINER_NAME)
+	t := &task{
+		master:        m,
+		agent:         a,
+		info:          info,
+		taskType:      taskType,
+		containerName: containerName,
+		state:         mesos.TaskState_TASK_STAGING,
+		killed:        make(chan struct{}),
+		aborted:       make(chan struct{}),
+	}
+	for _, resource := range info.Resources {
+		switch resource.GetName() {
+		case "cpus":
+			t.cpus += resource.GetScalar().GetValue()
+		case "mem":
+			t.mem += resource.GetScalar().GetValue()
+		case "disk":
+			t.disk += resource.GetScalar().GetValue()
+		}
+	}
+	return t
+}
+
+// kill stops the task, which reports TASK_KILLED.
+func (t *task) kill() {
+	t.killOnce.Do(func() { close(t.killed) })
+}
+
+// abort stops the task without a word, as when the framework goes away.
+func (t *task) abort() {
+	t.stopOnce.Do(func() { close(t.aborted) })
+}
+
+// wait sleeps for d and reports whether the task may go on.
+func (t *task) wait(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-t.killed:
+		t.report(mesos.TaskState_TASK_KILLED, "killed")
+		return false
+	case <-t.aborted:
+		return false
+	}
+}
+
+func (t *task) run() {
+	defer t.master.finish(t)
+	latency, fail := t.master.plan(t.taskType)
+	if !t.wait(stagingDelay) {
+		return
+	}
+	t.report(mesos.TaskState_TASK_RUNNING, "")
+
+	taskSteps := steps[t.taskType]
+	if len(taskSteps) == 0 {
+		taskSteps = []step{{"", 1}}
+	}
+	if t.taskType == shared.TaskTypes.CHECKPOINT_CONTAINER {
+		t.progress(shared.Progress{Phase: shared.ProgressPhases.LAST_LOG, Done: true, LogTime: time.Now()})
+	}
+	for i, s := range taskSteps {
+		if fail && i >= (len(taskSteps)+1)/2 {
+			t.report(mesos.TaskState_TASK_FAILED, "simulated failure")
+			return
+		}
+		d := time.Duration(float64(latency) * s.share)
+		if s.phase != "" {
+			t.progress(shared.Progress{Phase: s.phase})
+		}
+		if !t.wait(d) {
+			return
+		}
+		if s.phase != "" {
+			p := shared.Progress{Phase: s.phase, Done: true, Duration: d}
+			switch s.phase {
+			case shared.ProgressPhases.ARCHIVING, shared.ProgressPhases.UPLOADING, shared.ProgressPhases.DOWNLOADING:
+				p.Bytes = simulatedImage
+			}
+			t.progress(p)
+		}
+	}
+	if fail {
+		t.report(mesos.TaskState_TASK_FAILED, "simulated failure")
+		return
+	}
+	if t.taskType == shared.TaskTypes.RESTORE_CONTAINER {
+		// the restored container logs on its next tick
+		if !t.wait(logInterval / 2) {
+			return
+		}
+		t.progress(shared.Progress{Phase: shared.ProgressPhases.FIRST_LOG, Done: true, LogTime: time.Now()})
+	}
+	t.report(mesos.TaskState_TASK_FINISHED, "")
+}
+
+// report sends a status update from the executor. Like the real executor, it
+// sends the task's labels along.
+func (t *task) report(state mesos.TaskState, message string) {
+	m := t.master
+	m.lock.Lock()
+	t.state = state
+	m.lock.Unlock()
+	status := util.NewTaskStatus(t.info.GetTaskId(), state)
+	status.SlaveId = t.info.SlaveId
+	status.Labels = t.info.Labels
+	status.Source = mesos.TaskStatus_SOURCE_EXECUTOR.Enum()
+	if message != "" {
+		status.Message = proto.String(message)
+	}
+	m.call(func() { m.scheduler.StatusUpdate(m, status) })
+}
+
+// progress sends a progress message from the executor.
+func (t *task) progress(p shared.Progress) {
+	p.TaskId = t.info.GetTaskId().GetValue()
+	p.Time = time.Now()
+	msg, err := shared.EncodeProgress(p)
+	if err != nil {
+		log.Errorf("Failed to encode progress of simulated task %s: %v", p.TaskId, err)
+		return
+	}
+	m := t.master
+	executorId := t.info.GetExecutor().GetExecutorId()
+	slaveId := t.info.GetSlaveId()
+	m.call(func() { m.scheduler.FrameworkMessage(m, executorId, slaveId, msg) })
+}
+
+// reconcile returns the status the master reports for the task. The caller
+// must hold master.lock.
+func (t *task) reconcile() *mesos.TaskStatus {
+	status := masterStatus(t.info.GetTaskId(), t.state, mesos.TaskStatus_REASON_RECONCILIATION)
+	status.SlaveId = util.NewSlaveID(t.agent.slaveId)
+	return status
+}
+
+// plan returns how long a task of taskType runs and whether it fails.
+func (m *Master) plan(taskType string) (time.Duration, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	latency, ok := m.config.Latencies[taskType]
+	if !ok {
+		latency = m.config.Latency
+	}
+	latency += time.Duration(float64(latency) * m.config.Jitter * (2*m.rand.Float64() - 1))
+	return latency, m.rand.Float64() < m.config.FailureRate
+}
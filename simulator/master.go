@@ -0,0 +1,435 @@
+// Package simulator runs a scheduler against an in-process fake master and
+// simulated executors, so that placement, queueing and migrations can be
+// tried out without a Mesos cluster.
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	sched "github.com/mesos/mesos-go/scheduler"
+	"github.com/emc-cmd/test-framework/shared"
+)
+
+var _ sched.SchedulerDriver = &Master{}
+
+type agent struct {
+	slaveId    string
+	host       string
+	attributes map[string]string
+	cpus       float64 //free resources
+	mem        float64
+	disk       float64
+	offerId    string //outstanding offer, if any
+	refusedTil time.Time
+}
+
+// Master plays both the Mesos master and the scheduler driver: it offers the
+// resources of its simulated hosts to the scheduler, runs the tasks the
+// scheduler launches on simulated executors, and reports their statuses.
+// Like the real driver, it calls the scheduler from one goroutine at a time.
+type Master struct {
+	lock        sync.Mutex
+	config      Config
+	scheduler   sched.Scheduler
+	framework   *mesos.FrameworkInfo
+	frameworkId *mesos.FrameworkID
+	agents      []*agent
+	offers      map[string]*agent //outstanding offers by ID
+	tasks       map[string]*task  //running tasks by ID
+	containers  map[string]*task  //the tasks that started the running containers, by container name
+	rand        *rand.Rand
+	offerSeq    int
+	status      mesos.Status
+	callLock    sync.Mutex
+	calls       []func() //scheduler callbacks waiting to be made, guarded by callLock
+	wake        chan struct{}
+	done        chan struct{}
+}
+
+// NewMaster returns a master of a cluster described by config, which drives
+// scheduler.
+func NewMaster(scheduler sched.Scheduler, framework *mesos.FrameworkInfo, config Config) (*Master, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	m := &Master{
+		config:     config,
+		scheduler:  scheduler,
+		framework:  framework,
+		offers:     make(map[string]*agent),
+		tasks:      make(map[string]*task),
+		containers: make(map[string]*task),
+		rand:       rand.New(rand.NewSource(config.Seed)),
+		status:     mesos.Status_DRIVER_NOT_STARTED,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	for i := 0; i < config.Hosts; i++ {
+		a := &agent{
+			slaveId:    fmt.Sprintf("sim-slave-%d", i+1),
+			host:       fmt.Sprintf("sim-host-%d", i+1),
+			attributes: map[string]string{},
+			cpus:       config.Cpus,
+			mem:        config.Mem,
+			disk:       config.Disk,
+		}
+		for name, values := range config.Attributes {
+			a.attributes[name] = values[i%len(values)]
+		}
+		m.agents = append(m.agents, a)
+	}
+	return m, nil
+}
+
+// call queues a scheduler callback. Callbacks are made in order, from the
+// dispatch goroutine, so driver calls made by the scheduler don't block.
+// It may be called with m.lock held.
+func (m *Master) call(f func()) {
+	m.callLock.Lock()
+	m.calls = append(m.calls, f)
+	m.callLock.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Master) dispatch() {
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.wake:
+		}
+		for {
+			m.callLock.Lock()
+			if len(m.calls) == 0 {
+				m.callLock.Unlock()
+				break
+			}
+			f := m.calls[0]
+			m.calls = m.calls[1:]
+			m.callLock.Unlock()
+			f()
+		}
+	}
+}
+
+func (m *Master) offerLoop() {
+	ticker := time.NewTicker(m.config.OfferInterval)
+	defer ticker.Stop()
+	for {
+		m.makeOffers()
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// makeOffers offers the free resources of every host that has no
+// outstanding offer and isn't filtered.
+func (m *Master) makeOffers() {
+	m.lock.Lock()
+	now := time.Now()
+	offers := []*mesos.Offer{}
+	for _, a := range m.agents {
+		if a.offerId != "" || now.Before(a.refusedTil) || a.cpus <= 0 || a.mem <= 0 {
+			continue
+		}
+		m.offerSeq++
+		a.offerId = fmt.Sprintf("sim-offer-%d", m.offerSeq)
+		m.offers[a.offerId] = a
+		offer := util.NewOffer(util.NewOfferID(a.offerId), m.frameworkId, util.NewSlaveID(a.slaveId), a.host)
+		offer.Resources = []*mesos.Resource{util.NewScalarResource("cpus", a.cpus), util.NewScalarResource("mem", a.mem)}
+		if a.disk > 0 {
+			offer.Resources = append(offer.Resources, util.NewScalarResource("disk", a.disk))
+		}
+		for name, value := range a.attributes {
+			offer.Attributes = append(offer.Attributes, &mesos.Attribute{
+				Name: proto.String(name),
+				Type: mesos.Value_TEXT.Enum(),
+				Text: &mesos.Value_Text{Value: proto.String(value)},
+			})
+		}
+		offers = append(offers, offer)
+	}
+	m.lock.Unlock()
+	if len(offers) > 0 {
+		m.call(func() { m.scheduler.ResourceOffers(m, offers) })
+	}
+}
+
+// takeOffer removes an outstanding offer. The caller must hold m.lock.
+func (m *Master) takeOffer(offerId string) (*agent, bool) {
+	a, ok := m.offers[offerId]
+	if !ok {
+		return nil, false
+	}
+	delete(m.offers, offerId)
+	a.offerId = ""
+	return a, true
+}
+
+func (m *Master) Start() (mesos.Status, error) {
+	m.lock.Lock()
+	if m.status != mesos.Status_DRIVER_NOT_STARTED {
+		defer m.lock.Unlock()
+		return m.status, fmt.Errorf("simulator is already %s", m.status)
+	}
+	m.status = mesos.Status_DRIVER_RUNNING
+	frameworkId := m.framework.GetId()
+	if frameworkId == nil {
+		frameworkId = util.NewFrameworkID(fmt.Sprintf("sim-framework-%d", time.Now().UnixNano()))
+	}
+	m.frameworkId = frameworkId
+	m.lock.Unlock()
+
+	log.Infof("Simulating %d hosts with cpus=%v mem=%v disk=%v", m.config.Hosts, m.config.Cpus, m.config.Mem, m.config.Disk)
+	go m.dispatch()
+	masterInfo := &mesos.MasterInfo{
+		Id:       proto.String("sim-master"),
+		Ip:       proto.Uint32(0x7f000001),
+		Port:     proto.Uint32(5050),
+		Hostname: proto.String("sim-master"),
+	}
+	m.call(func() { m.scheduler.Registered(m, frameworkId, masterInfo) })
+	go m.offerLoop()
+	return mesos.Status_DRIVER_RUNNING, nil
+}
+
+func (m *Master) stop(status mesos.Status) (mesos.Status, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.status != mesos.Status_DRIVER_RUNNING {
+		return m.status, fmt.Errorf("simulator is not running")
+	}
+	m.status = status
+	for _, t := range m.tasks {
+		t.abort()
+	}
+	close(m.done)
+	return status, nil
+}
+
+func (m *Master) Stop(failover bool) (mesos.Status, error) {
+	return m.stop(mesos.Status_DRIVER_STOPPED)
+}
+
+func (m *Master) Abort() (mesos.Status, error) {
+	return m.stop(mesos.Status_DRIVER_ABORTED)
+}
+
+func (m *Master) Join() (mesos.Status, error) {
+	<-m.done
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.status, nil
+}
+
+func (m *Master) Run() (mesos.Status, error) {
+	if status, err := m.Start(); err != nil {
+		return status, err
+	}
+	return m.Join()
+}
+
+func (m *Master) RequestResources(requests []*mesos.Request) (mesos.Status, error) {
+	return m.running()
+}
+
+func (m *Master) running() (mesos.Status, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.status != mesos.Status_DRIVER_RUNNING {
+		return m.status, fmt.Errorf("simulator is not running")
+	}
+	return m.status, nil
+}
+
+// AcceptOffers launches the tasks of LAUNCH operations. Reservations and
+// volumes aren't simulated, so other operations leave the resources as
+// they are.
+func (m *Master) AcceptOffers(offerIds []*mesos.OfferID, operations []*mesos.Offer_Operation, filters *mesos.Filters) (mesos.Status, error) {
+	tasks := []*mesos.TaskInfo{}
+	for _, operation := range operations {
+		if operation.GetType() == mesos.Offer_Operation_LAUNCH {
+			tasks = append(tasks, operation.GetLaunch().GetTaskInfos()...)
+		} else {
+			log.Infof("Simulator ignores %s operation", operation.GetType())
+		}
+	}
+	return m.LaunchTasks(offerIds, tasks, filters)
+}
+
+func (m *Master) LaunchTasks(offerIds []*mesos.OfferID, tasks []*mesos.TaskInfo, filters *mesos.Filters) (mesos.Status, error) {
+	if status, err := m.running(); err != nil {
+		return status, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var a *agent
+	for _, offerId := range offerIds {
+		taken, ok := m.takeOffer(offerId.GetValue())
+		if !ok || (a != nil && taken != a) {
+			a = nil
+			break
+		}
+		a = taken
+	}
+	for _, info := range tasks {
+		if a == nil {
+			m.lose(info, mesos.TaskStatus_REASON_INVALID_OFFERS, "offer is no longer valid")
+			continue
+		}
+		t := newTask(m, a, info)
+		if t.cpus > a.cpus || t.mem > a.mem || t.disk > a.disk {
+			m.lose(info, mesos.TaskStatus_REASON_INVALID_OFFERS, "task uses more resources than offered")
+			continue
+		}
+		a.cpus -= t.cpus
+		a.mem -= t.mem
+		a.disk -= t.disk
+		m.tasks[info.GetTaskId().GetValue()] = t
+		go t.run()
+	}
+	return m.status, nil
+}
+
+// lose reports a task as lost. The caller must hold m.lock.
+func (m *Master) lose(info *mesos.TaskInfo, reason mesos.TaskStatus_Reason, message string) {
+	status := masterStatus(info.GetTaskId(), mesos.TaskState_TASK_LOST, reason)
+	status.SlaveId = info.SlaveId
+	status.Labels = info.Labels
+	status.Message = proto.String(message)
+	m.call(func() { m.scheduler.StatusUpdate(m, status) })
+}
+
+// finish gives the resources of a task back to its host. A container that a
+// task started keeps the task's cpus and mem until it is checkpointed, which
+// stops it.
+func (m *Master) finish(t *task) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.tasks, t.info.GetTaskId().GetValue())
+	t.agent.disk += t.disk
+	finished := t.state == mesos.TaskState_TASK_FINISHED
+	switch {
+	case finished && (t.taskType == shared.TaskTypes.RUN_CONTAINER || t.taskType == shared.TaskTypes.RESTORE_CONTAINER):
+		m.stopContainer(t.containerName)
+		m.containers[t.containerName] = t
+		return
+	case finished && t.taskType == shared.TaskTypes.CHECKPOINT_CONTAINER:
+		m.stopContainer(t.containerName)
+	}
+	t.agent.cpus += t.cpus
+	t.agent.mem += t.mem
+}
+
+// stopContainer gives the cpus and mem of a running container back to its
+// host. The caller must hold m.lock.
+func (m *Master) stopContainer(containerName string) {
+	t, ok := m.containers[containerName]
+	if !ok {
+		return
+	}
+	delete(m.containers, containerName)
+	t.agent.cpus += t.cpus
+	t.agent.mem += t.mem
+}
+
+func (m *Master) KillTask(taskId *mesos.TaskID) (mesos.Status, error) {
+	if status, err := m.running(); err != nil {
+		return status, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t, ok := m.tasks[taskId.GetValue()]
+	if !ok {
+		status := masterStatus(taskId, mesos.TaskState_TASK_LOST, mesos.TaskStatus_REASON_TASK_UNKNOWN)
+		m.call(func() { m.scheduler.StatusUpdate(m, status) })
+		return m.status, nil
+	}
+	t.kill()
+	return m.status, nil
+}
+
+func (m *Master) DeclineOffer(offerId *mesos.OfferID, filters *mesos.Filters) (mesos.Status, error) {
+	if status, err := m.running(); err != nil {
+		return status, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	a, ok := m.takeOffer(offerId.GetValue())
+	if !ok {
+		return m.status, nil
+	}
+	refuse := 5.0
+	if filters != nil && filters.RefuseSeconds != nil {
+		refuse = filters.GetRefuseSeconds()
+	}
+	a.refusedTil = time.Now().Add(time.Duration(refuse * float64(time.Second)))
+	return m.status, nil
+}
+
+func (m *Master) ReviveOffers() (mesos.Status, error) {
+	if status, err := m.running(); err != nil {
+		return status, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, a := range m.agents {
+		a.refusedTil = time.Time{}
+	}
+	return m.status, nil
+}
+
+func (m *Master) SendFrameworkMessage(executorId *mesos.ExecutorID, slaveId *mesos.SlaveID, data string) (mesos.Status, error) {
+	log.Infof("Simulated executor %s on %s got message: %s", executorId.GetValue(), slaveId.GetValue(), data)
+	return m.running()
+}
+
+// ReconcileTasks reports the state of the given tasks, or of every running
+// task if statuses is empty. Tasks the master doesn't know are lost.
+func (m *Master) ReconcileTasks(statuses []*mesos.TaskStatus) (mesos.Status, error) {
+	if status, err := m.running(); err != nil {
+		return status, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	reports := []*mesos.TaskStatus{}
+	if len(statuses) == 0 {
+		for _, t := range m.tasks {
+			reports = append(reports, t.reconcile())
+		}
+	}
+	for _, status := range statuses {
+		if t, ok := m.tasks[status.GetTaskId().GetValue()]; ok {
+			reports = append(reports, t.reconcile())
+			continue
+		}
+		reports = append(reports, masterStatus(status.GetTaskId(), mesos.TaskState_TASK_LOST, mesos.TaskStatus_REASON_RECONCILIATION))
+	}
+	for _, report := range reports {
+		report := report
+		m.call(func() { m.scheduler.StatusUpdate(m, report) })
+	}
+	return m.status, nil
+}
+
+// masterStatus returns a status update the master sends on its own. Unlike
+// the executor's, it doesn't carry the task's labels.
+func masterStatus(taskId *mesos.TaskID, state mesos.TaskState, reason mesos.TaskStatus_Reason) *mesos.TaskStatus {
+	status := util.NewTaskStatus(taskId, state)
+	status.Reason = reason.Enum()
+	status.Source = mesos.TaskStatus_SOURCE_MASTER.Enum()
+	return status
+}
@@ -0,0 +1,107 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	util "github.com/mesos/mesos-go/mesosutil"
+	"github.com/emc-cmd/test-framework/scheduler"
+)
+
+func TestMigrationInSimulation(t *testing.T) {
+	exec := util.NewExecutorInfo(util.NewExecutorID("default"), util.NewCommandInfo("./executor"))
+	s := scheduler.NewExampleScheduler(exec, 0, 1, 128, "http://fileserver")
+	master, err := NewMaster(s, &mesos.FrameworkInfo{}, Config{
+		Hosts:         2,
+		Cpus:          2,
+		Mem:           1024,
+		OfferInterval: 10 * time.Millisecond,
+		Latency:       20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := master.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Stop(false)
+
+	waitFor := func(what string, done func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	s.RunContainerTask("web")
+	var source string
+	waitFor("the container to run", func() bool {
+		host, ok := s.GetContainerHost("web")
+		source = host
+		return ok
+	})
+	target := "sim-host-1"
+	if source == target {
+		target = "sim-host-2"
+	}
+	migrationId, err := s.MigrateContainerTask("web", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor("the migration to finish", func() bool {
+		migration, _ := s.GetMigration(migrationId)
+		return migration.State == scheduler.MigrationStates.DONE
+	})
+	if host, _ := s.GetContainerHost("web"); host != target {
+		t.Errorf("container is on %q, want %q", host, target)
+	}
+	timings := s.Timings("web")
+	if len(timings) != 1 || !timings[0].Complete || timings[0].Downtime <= 0 {
+		t.Errorf("timings are %+v, want one complete timing with downtime", timings)
+	}
+}
+
+func TestContainersKeepTheirResources(t *testing.T) {
+	exec := util.NewExecutorInfo(util.NewExecutorID("default"), util.NewCommandInfo("./executor"))
+	s := scheduler.NewExampleScheduler(exec, 0, 1, 128, "http://fileserver")
+	master, err := NewMaster(s, &mesos.FrameworkInfo{}, Config{
+		Hosts:         1,
+		Cpus:          2,
+		Mem:           1024,
+		OfferInterval: 10 * time.Millisecond,
+		Latency:       20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := master.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Stop(false)
+
+	freeCpus := func(want float64) func() bool {
+		return func() bool {
+			master.lock.Lock()
+			defer master.lock.Unlock()
+			return len(master.tasks) == 0 && master.agents[0].cpus == want
+		}
+	}
+	waitFor := func(what string, done func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	s.RunContainerTask("web")
+	waitFor("the container to hold its cpus", freeCpus(1))
+	s.CheckpointContainerTask("web")
+	waitFor("the checkpoint to free the container's cpus", freeCpus(2))
+}
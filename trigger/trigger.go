@@ -2,17 +2,41 @@ package trigger
 
 import (
 	"github.com/go-martini/martini"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/emc-cmd/test-framework/scheduler"
 )
 
 func RunTriggerServer(sched *scheduler.ExampleScheduler) {
 	m := martini.Classic()
 	m.Get("/", func() string {
-		instructions := fmt.Sprintf("GET / for help\nGET /create/:container_id\nGET /checkpoint/:container_id\nGET /restore/:container_id")
+		instructions := fmt.Sprintf("GET / for help\nGET /create/:container_id[?cpus=&mem=&disk=&group=&constraint=field:OPERATOR[:value]&recovery=none|last-checkpoint|restart-fresh&strategy=first-fit|bin-pack|spread|random]\nGET /checkpoint/:container_id\nGET /restore/:container_id[?strategy=]\nGET /restore/:container_id/:target_host\nGET /queue\nGET /queue/cancel/:task_id\nGET /failures\nGET /failures/:container_id\nGET /recovery/:container_id/:policy\nGET /lost\nGET /migrate/:container_id/:target_host\nGET /migrations\nGET /migrations/:migration_id\nGET /timings[?container=&format=json|csv]\nGET /timings/:container_id[?format=json|csv]\nGET /tasks[?container=]\nGET /tasks/:task_id\nGET /tasks/:task_id/kill\nGET /schedule/:container_id?every=15m|cron=0 */15 * * * *[&retention=3]\nGET /schedule/:container_id/remove\nGET /schedules\nGET /hosts/:host/drain[?concurrency=]\nGET /hosts/:host/drain/status\nGET /hosts/:host/undrain\nGET /drains\nGET /maintenance\nGET /rebalance\nGET /rebalance/run[?dry_run=true]\nGET /events[?type=TASK_STATE&type=...] (server-sent events)\nGET /metrics (Prometheus)")
 		return instructions
 	})
-	m.Get("/create/:container_name", func(params martini.Params) string {
+	m.Get("/create/:container_name", func(params martini.Params, req *http.Request) string {
+		if resources, err := resourcesFromQuery(req); err != nil {
+			return fmt.Sprintf("Error: %s", err.Error())
+		} else if resources != nil {
+			if err := sched.SetContainerResources(params["container_name"], *resources); err != nil {
+				return fmt.Sprintf("Error: %s", err.Error())
+			}
+		}
+		if placement, err := placementFromQuery(req); err != nil {
+			return fmt.Sprintf("Error: %s", err.Error())
+		} else if placement != nil {
+			if err := sched.SetPlacement(params["container_name"], *placement); err != nil {
+				return fmt.Sprintf("Error: %s", err.Error())
+			}
+		}
+		if policy := req.URL.Query().Get("recovery"); policy != "" {
+			if err := sched.SetRecoveryPolicy(params["container_name"], policy); err != nil {
+				return fmt.Sprintf("Error: %s", err.Error())
+			}
+		}
 		sched.RunContainerTask(params["container_name"])
 		return fmt.Sprintf("RunContainerTask queued...\nTask Queue: %v", sched.TaskQueue)
 	})
@@ -20,6 +44,16 @@ func RunTriggerServer(sched *scheduler.ExampleScheduler) {
 		sched.CheckpointContainerTask(params["container_name"])
 		return fmt.Sprintf("CheckpointContainerTask queued...\nTask Queue: %v", sched.TaskQueue)
 	})
+	m.Get("/restore/:container_name", func(params martini.Params, req *http.Request) string {
+		if strategy := req.URL.Query().Get("strategy"); strategy != "" {
+			if err := sched.RestoreContainerTaskWithStrategy(params["container_name"], strategy); err != nil {
+				return fmt.Sprintf("Error: %s", err.Error())
+			}
+		} else {
+			sched.RestoreContainerTask(params["container_name"], "")
+		}
+		return fmt.Sprintf("RestoreContainerTask queued...\nTask Queue: %v", sched.TaskQueue)
+	})
 	m.Get("/restore/:container_name/:target_host", func(params martini.Params) string {
 		sched.RestoreContainerTask(params["container_name"], params["target_host"])
 		return fmt.Sprintf("RestoreContainerTask queued...\nTask Queue: %v", sched.TaskQueue)
@@ -30,6 +64,275 @@ func RunTriggerServer(sched *scheduler.ExampleScheduler) {
 		sched.GetLogsTask(params["container_name"])
 		return fmt.Sprintf("GetLogsTask queued...\nTask Queue: %v", sched.TaskQueue)
 	})
+	m.Get("/queue", func() string {
+		return fmt.Sprintf("Task Queue: %v", sched.TaskQueue)
+	})
+	m.Get("/queue/cancel/:task_id", func(params martini.Params) string {
+		if !sched.CancelTask(params["task_id"]) {
+			return fmt.Sprintf("Task %s is not queued\nTask Queue: %v", params["task_id"], sched.TaskQueue)
+		}
+		return fmt.Sprintf("Task %s cancelled...\nTask Queue: %v", params["task_id"], sched.TaskQueue)
+	})
+	m.Get("/failures", func() string {
+		return toJson(sched.Failures(""))
+	})
+	m.Get("/failures/:container_name", func(params martini.Params) string {
+		return toJson(sched.Failures(params["container_name"]))
+	})
+	m.Get("/recovery/:container_name/:policy", func(params martini.Params) string {
+		if err := sched.SetRecoveryPolicy(params["container_name"], params["policy"]); err != nil {
+			return fmt.Sprintf("Error: %s", err.Error())
+		}
+		return fmt.Sprintf("Recovery policy of %s set to %s", params["container_name"], params["policy"])
+	})
+	m.Get("/lost", func() string {
+		return toJson(sched.LostContainers())
+	})
+	m.Get("/migrate/:container_name/:target_host", func(params martini.Params) string {
+		migrationId, err := sched.MigrateContainerTask(params["container_name"], params["target_host"])
+		if err != nil {
+			return fmt.Sprintf("Error: %s", err.Error())
+		}
+		return fmt.Sprintf("Migration %s queued...\nTask Queue: %v", migrationId, sched.TaskQueue)
+	})
+	m.Get("/migrations", func() string {
+		return toJson(sched.Migrations())
+	})
+	m.Get("/migrations/:migration_id", func(params martini.Params) (int, string) {
+		migration, ok := sched.GetMigration(params["migration_id"])
+		if !ok {
+			return http.StatusNotFound, fmt.Sprintf("Migration %s not found", params["migration_id"])
+		}
+		return http.StatusOK, toJson(migration)
+	})
+	m.Get("/timings", func(w http.ResponseWriter, req *http.Request) {
+		writeTimings(w, req, sched.Timings(req.URL.Query().Get("container")))
+	})
+	m.Get("/timings/:container_name", func(params martini.Params, w http.ResponseWriter, req *http.Request) {
+		writeTimings(w, req, sched.Timings(params["container_name"]))
+	})
+	m.Get("/tasks", func(req *http.Request) string {
+		return toJson(sched.Tasks.List(req.URL.Query().Get("container")))
+	})
+	m.Get("/tasks/:task_id", func(params martini.Params) (int, string) {
+		record, ok := sched.Tasks.Get(params["task_id"])
+		if !ok {
+			return http.StatusNotFound, fmt.Sprintf("Task %s not found", params["task_id"])
+		}
+		return http.StatusOK, toJson(record)
+	})
+	m.Get("/tasks/:task_id/kill", func(params martini.Params) (int, string) {
+		if err := sched.KillTask(params["task_id"]); err != nil {
+			return http.StatusConflict, fmt.Sprintf("Error: %s", err.Error())
+		}
+		return http.StatusOK, fmt.Sprintf("Task %s is being killed...", params["task_id"])
+	})
+	m.Get("/schedule/:container_name", func(params martini.Params, req *http.Request) string {
+		query := req.URL.Query()
+		var every time.Duration
+		if query.Get("every") != "" {
+			var err error
+			if every, err = time.ParseDuration(query.Get("every")); err != nil {
+				return fmt.Sprintf("Error: invalid interval %q", query.Get("every"))
+			}
+		}
+		retention := 1
+		if query.Get("retention") != "" {
+			var err error
+			if retention, err = strconv.Atoi(query.Get("retention")); err != nil {
+				return fmt.Sprintf("Error: invalid retention %q", query.Get("retention"))
+			}
+		}
+		if err := sched.SetCheckpointSchedule(params["container_name"], every, query.Get("cron"), retention); err != nil {
+			return fmt.Sprintf("Error: %s", err.Error())
+		}
+		return fmt.Sprintf("Checkpoint schedule of %s set", params["container_name"])
+	})
+	m.Get("/schedule/:container_name/remove", func(params martini.Params) string {
+		if !sched.RemoveCheckpointSchedule(params["container_name"]) {
+			return fmt.Sprintf("%s has no checkpoint schedule", params["container_name"])
+		}
+		return fmt.Sprintf("Checkpoint schedule of %s removed", params["container_name"])
+	})
+	m.Get("/schedules", func() string {
+		return toJson(sched.CheckpointSchedules())
+	})
+	m.Get("/hosts/:host/drain", func(params martini.Params, req *http.Request) string {
+		concurrency := 0
+		if value := req.URL.Query().Get("concurrency"); value != "" {
+			var err error
+			if concurrency, err = strconv.Atoi(value); err != nil || concurrency < 1 {
+				return fmt.Sprintf("Error: invalid concurrency %q", value)
+			}
+		}
+		if err := sched.DrainHost(params["host"], concurrency); err != nil {
+			return fmt.Sprintf("Error: %s", err.Error())
+		}
+		return fmt.Sprintf("Draining %s, see /hosts/%s/drain/status", params["host"], params["host"])
+	})
+	m.Get("/hosts/:host/drain/status", func(params martini.Params) (int, string) {
+		drain, ok := sched.GetDrain(params["host"])
+		if !ok {
+			return http.StatusNotFound, fmt.Sprintf("%s is not draining", params["host"])
+		}
+		return http.StatusOK, toJson(drain)
+	})
+	m.Get("/hosts/:host/undrain", func(params martini.Params) string {
+		if !sched.UndrainHost(params["host"]) {
+			return fmt.Sprintf("%s is not draining", params["host"])
+		}
+		return fmt.Sprintf("%s is back in rotation", params["host"])
+	})
+	m.Get("/drains", func() string {
+		return toJson(sched.Drains())
+	})
+	m.Get("/maintenance", func() string {
+		return toJson(sched.MaintenanceWindows())
+	})
+	m.Get("/rebalance", func() (int, string) {
+		report, ok := sched.LastRebalance()
+		if !ok {
+			return http.StatusNotFound, "The rebalancer has not run yet"
+		}
+		return http.StatusOK, toJson(report)
+	})
+	m.Get("/rebalance/run", func(req *http.Request) string {
+		return toJson(sched.Rebalance(req.URL.Query().Get("dry_run") == "true"))
+	})
+	m.Get("/metrics", promhttp.Handler().ServeHTTP)
+	m.Get("/events", func(w http.ResponseWriter, req *http.Request) {
+		streamEvents(sched.Events, w, req)
+	})
 
 	m.Run()
-}
\ No newline at end of file
+}
+
+// streamEvents sends events to the client as server-sent events until it
+// disconnects. A client that reconnects with a Last-Event-ID header (or an
+// after query parameter) first gets the events it missed, as far as they
+// are still kept.
+func streamEvents(bus *scheduler.EventBus, w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	lastId := req.Header.Get("Last-Event-ID")
+	if lastId == "" {
+		lastId = req.URL.Query().Get("after")
+	}
+	var after uint64
+	if lastId != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastId, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid event ID %q", lastId), http.StatusBadRequest)
+			return
+		}
+	}
+	types := map[string]bool{}
+	for _, t := range req.URL.Query()["type"] {
+		types[t] = true
+	}
+
+	events, cancel := bus.Subscribe(after)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				// fell behind, the client reconnects from the last ID it saw
+				return
+			}
+			if len(types) > 0 && !types[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// resourcesFromQuery reads the cpus, mem and disk query parameters. It
+// returns nil if none of them is set.
+func resourcesFromQuery(req *http.Request) (*scheduler.ContainerResources, error) {
+	query := req.URL.Query()
+	if query.Get("cpus") == "" && query.Get("mem") == "" && query.Get("disk") == "" {
+		return nil, nil
+	}
+	resources := &scheduler.ContainerResources{}
+	for name, value := range map[string]*float64{"cpus": &resources.Cpus, "mem": &resources.Mem, "disk": &resources.Disk} {
+		if query.Get(name) == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(query.Get(name), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", name, query.Get(name))
+		}
+		*value = parsed
+	}
+	return resources, nil
+}
+
+// placementFromQuery reads the group, constraint and strategy query
+// parameters. It returns nil if none of them is set.
+func placementFromQuery(req *http.Request) (*scheduler.Placement, error) {
+	query := req.URL.Query()
+	if query.Get("group") == "" && len(query["constraint"]) == 0 && query.Get("strategy") == "" {
+		return nil, nil
+	}
+	placement := &scheduler.Placement{Group: query.Get("group"), Strategy: query.Get("strategy")}
+	if _, ok := scheduler.PlacementStrategies[placement.Strategy]; placement.Strategy != "" && !ok {
+		return nil, fmt.Errorf("unknown placement strategy %q", placement.Strategy)
+	}
+	for _, s := range query["constraint"] {
+		constraint, err := scheduler.ParseConstraint(s)
+		if err != nil {
+			return nil, err
+		}
+		placement.Constraints = append(placement.Constraints, constraint)
+	}
+	return placement, nil
+}
+
+// writeTimings writes timings as JSON, or as CSV with ?format=csv.
+func writeTimings(w http.ResponseWriter, req *http.Request, timings []scheduler.MigrationTiming) {
+	switch req.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, toJson(timings))
+	case "csv":
+		body, err := scheduler.TimingsCSV(timings)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error encoding timings: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="timings.csv"`)
+		fmt.Fprint(w, body)
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+func toJson(v interface{}) string {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("Error encoding response: %s", err.Error())
+	}
+	return string(body)
+}